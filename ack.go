@@ -0,0 +1,112 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+*/
+import "C"
+import "sync"
+
+// msgAck acknowledges or recovers a message received in a client
+// acknowledge mode. It owns the message until it is acknowledged or
+// recovered, and the session when that was created for a single receive.
+type msgAck struct {
+	client      *Client
+	msg         C.tibemsMsg
+	session     C.tibemsSession
+	ownsSession bool
+	done        bool
+	sync.Mutex
+}
+
+// newAck returns the acker for a received message and tracks it until it
+// is released.
+func (c *Client) newAck(msg C.tibemsMsg, session C.tibemsSession, ownsSession bool) *msgAck {
+
+	a := &msgAck{client: c, msg: msg, session: session, ownsSession: ownsSession}
+
+	c.acksLock.Lock()
+	if c.acks == nil {
+		c.acks = make(map[*msgAck]struct{})
+	}
+	c.acks[a] = struct{}{}
+	c.acksLock.Unlock()
+
+	return a
+}
+
+func (a *msgAck) ack() error {
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.done {
+		return newError(TIBEMS_ILLEGAL_STATE, "message already acknowledged or recovered", "")
+	}
+
+	var err error
+	if status := C.tibemsMsg_Acknowledge(a.msg); status != TIBEMS_OK {
+		err = a.client.newError(status)
+	}
+	a.release()
+
+	return err
+}
+
+func (a *msgAck) recover() error {
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.done {
+		return newError(TIBEMS_ILLEGAL_STATE, "message already acknowledged or recovered", "")
+	}
+
+	var err error
+	if status := C.tibemsSession_Recover(a.session); status != TIBEMS_OK {
+		err = a.client.newError(status)
+	}
+	a.release()
+
+	return err
+}
+
+// release destroys the message and closes a session created for it.
+// Closing the session redelivers the message if it was not acknowledged.
+// a must be locked.
+func (a *msgAck) release() {
+
+	C.tibemsMsg_Destroy(a.msg)
+	if a.ownsSession {
+		C.tibemsSession_Close(a.session)
+	}
+	a.done = true
+
+	a.client.acksLock.Lock()
+	delete(a.client.acks, a)
+	a.client.acksLock.Unlock()
+}
+
+// releaseAcks releases the messages not yet acknowledged or recovered that
+// were received on session, or on any session if session is nil, so a later
+// Ack or Recover fails instead of using a closed session.
+func (c *Client) releaseAcks(session C.tibemsSession) {
+
+	c.acksLock.Lock()
+	acks := make([]*msgAck, 0, len(c.acks))
+	for a := range c.acks {
+		if session == nil || a.session == session {
+			acks = append(acks, a)
+		}
+	}
+	c.acksLock.Unlock()
+
+	for _, a := range acks {
+		a.Lock()
+		if !a.done {
+			a.release()
+		}
+		a.Unlock()
+	}
+}
@@ -0,0 +1,159 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+*/
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// QueueBrowser is a queue browser on its own session.
+type QueueBrowser struct {
+	client  *Client
+	dest    C.tibemsDestination
+	session C.tibemsSession
+	browser C.tibemsQueueBrowser
+	closed  bool
+	sync.Mutex
+}
+
+// Browse opens a browser over the messages on the queue, optionally limited
+// by WithSelector. Browsing does not remove or acknowledge messages.
+func (c *Client) Browse(queue string, opts ...ConsumerOption) (IQueueBrowser, error) {
+
+	o, err := newConsumerOptions("queue", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &QueueBrowser{client: c}
+
+	// create the destination
+	name := C.CString(queue)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsDestination_Create(&b.dest, toDestinationType("queue"), name)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+
+	// create the session
+	status = C.tibemsConnection_CreateSession(c.conn, &b.session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
+	if status != TIBEMS_OK {
+		err := c.newError(status)
+		C.tibemsDestination_Destroy(b.dest)
+		return nil, err
+	}
+
+	// create the browser
+	var msgSelector *C.char
+	if o.selector != "" {
+		msgSelector = C.CString(o.selector)
+		defer C.free(unsafe.Pointer(msgSelector))
+	}
+
+	status = C.tibemsSession_CreateBrowser(b.session, &b.browser, b.dest, msgSelector)
+	if status != TIBEMS_OK {
+		err := c.newError(status)
+		C.tibemsSession_Close(b.session)
+		C.tibemsDestination_Destroy(b.dest)
+		return nil, err
+	}
+
+	c.browsersLock.Lock()
+	if c.browsers == nil {
+		c.browsers = make(map[*QueueBrowser]struct{})
+	}
+	c.browsers[b] = struct{}{}
+	c.browsersLock.Unlock()
+
+	return b, nil
+}
+
+// Next returns the next message on the queue, or false when there are no more.
+func (b *QueueBrowser) Next() (Message, bool, error) {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return Message{}, false, newError(TIBEMS_ILLEGAL_STATE, "browser is closed", "")
+	}
+
+	var msg C.tibemsMsg
+
+	status := C.tibemsQueueBrowser_GetNext(b.browser, &msg)
+	if status == TIBEMS_NOT_FOUND || (status == TIBEMS_OK && msg == nil) {
+		return Message{}, false, nil
+	}
+	if status != TIBEMS_OK {
+		return Message{}, false, b.client.newError(status)
+	}
+	defer C.tibemsMsg_Destroy(msg)
+
+	m, err := b.client.readMsg(msg)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	return m, true, nil
+}
+
+// Close releases the browser and its session.
+func (b *QueueBrowser) Close() error {
+
+	b.client.browsersLock.Lock()
+	delete(b.client.browsers, b)
+	b.client.browsersLock.Unlock()
+
+	return b.close()
+}
+
+func (b *QueueBrowser) close() error {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	var err error
+
+	if status := C.tibemsQueueBrowser_Close(b.browser); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close browser", "")
+	}
+
+	if status := C.tibemsSession_Close(b.session); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close session", "")
+	}
+
+	if status := C.tibemsDestination_Destroy(b.dest); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to destroy destination", "")
+	}
+
+	return err
+}
+
+// closeBrowsers closes every open queue browser.
+func (c *Client) closeBrowsers() error {
+
+	c.browsersLock.Lock()
+	browsers := c.browsers
+	c.browsers = nil
+	c.browsersLock.Unlock()
+
+	var err error
+	for b := range browsers {
+		if e := b.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
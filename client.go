@@ -1,3 +1,5 @@
+//go:build tibems
+
 package ems
 
 /*
@@ -22,7 +24,7 @@ tibems_int castToInt(int value) {
 */
 import "C"
 import (
-	"errors"
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -30,77 +32,261 @@ import (
 	"unsafe"
 )
 
-type IClient interface {
-	IsConnected() bool
-	Connect() error
-	Disconnect() error
-	Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error
-	SendReceive(destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error)
-	Receive(destination string, destinationType string, timeout int) (string, bool, error)
-}
-
 type Client struct {
-	conn         C.tibemsConnection
-	cf           C.tibemsConnectionFactory
-	errorContext C.tibemsErrorContext
-	status       uint32
-	options      ClientOptions
+	conn          C.tibemsConnection
+	cf            C.tibemsConnectionFactory
+	errorContext  C.tibemsErrorContext
+	status        uint32
+	options       ClientOptions
+	producers     map[string]*producer
+	producersLock sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	subsLock      sync.Mutex
+	acks          map[*msgAck]struct{}
+	acksLock      sync.Mutex
+	transactions  map[*Tx]struct{}
+	txLock        sync.Mutex
+	browsers      map[*QueueBrowser]struct{}
+	browsersLock  sync.Mutex
+	replies       *replyQueue
+	replier       *replyProducer
+	replyLock     sync.Mutex
+	lookup        C.tibemsLookupContext
+	lookupLock    sync.Mutex
+	events        *connectionEvents
 	sync.RWMutex
 }
 
+// producer is a session and message producer kept open for a single
+// destination so that repeated sends avoid the setup round trips.
+// EMS sessions are single threaded, so sends through a producer are serialised.
+type producer struct {
+	key      string
+	dest     C.tibemsDestination
+	session  C.tibemsSession
+	producer C.tibemsMsgProducer
+	closed   bool
+	sync.Mutex
+}
+
 func NewClient(o *ClientOptions) IClient {
 
 	c := &Client{}
 	c.options = *o
 	c.status = disconnected
+	c.events = newConnectionEvents(o.eventListener)
 
 	return c
 }
 
+// IsConnected reports whether the client is connected, asking the EMS
+// library so that a connection lost since the last event is not reported
+// as connected.
 func (c *Client) IsConnected() bool {
 
 	c.RLock()
 	defer c.RUnlock()
 
-	return c.status == connected
+	if atomic.LoadUint32(&c.status) != connected || c.conn == nil {
+		return false
+	}
+
+	var disconnected C.tibems_bool
+	status := C.tibemsConnection_IsDisconnected(c.conn, &disconnected)
+
+	return status == TIBEMS_OK && disconnected == TIBEMS_FALSE
+}
 
+// Events returns the channel receiving the client's connection events. It is
+// shared by all callers and never closed; events are dropped while it is full.
+func (c *Client) Events() <-chan ConnectionEvent {
+	return c.events.ch
 }
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext connects to the server. A deadline on ctx bounds each
+// connection attempt; if ctx is done first ctx.Err() is returned and the
+// connection, should it still succeed, is closed.
+func (c *Client) ConnectContext(ctx context.Context) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctx.Done() == nil {
+		return c.connect(ctx)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.connect(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		go func() {
+			if err := <-done; err == nil {
+				c.Disconnect()
+			}
+		}()
+		return ctx.Err()
+	}
+}
+
+func (c *Client) connect(ctx context.Context) error {
 
 	c.RLock()
 	defer c.RUnlock()
 
+	// options from NewClient have not been through Validate
+	if err := c.options.Validate(); err != nil {
+		return err
+	}
+
 	status := C.tibemsErrorContext_Create(&c.errorContext)
 
 	if status != TIBEMS_OK {
-		return errors.New("failed to create error context")
+		return newError(int(status), "failed to create error context", "")
 	}
 
-	c.cf = C.tibemsConnectionFactory_Create()
+	user, pass, err := c.options.getCredentials(ctx)
+	if err != nil {
+		return err
+	}
 
-	url := c.options.GetServerUrl()
+	if c.options.connectionFactory != "" {
+		// the administered factory already has its server URLs
+		if err := c.lookupConnectionFactory(user, pass); err != nil {
+			return err
+		}
+	} else {
+		c.cf = C.tibemsConnectionFactory_Create()
 
-	status = C.tibemsConnectionFactory_SetServerURL(c.cf, C.CString(url.String()))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		url := C.CString(c.options.serverURLString())
+		defer C.free(unsafe.Pointer(url))
+
+		status = C.tibemsConnectionFactory_SetServerURL(c.cf, url)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	if c.options.clientID != "" {
+		cid := C.CString(c.options.clientID)
+		defer C.free(unsafe.Pointer(cid))
+
+		status = C.tibemsConnectionFactory_SetClientID(c.cf, cid)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	// a looked-up factory keeps the SSL settings of its administrators; the
+	// options' SSL parameters only secure the lookup
+	if c.options.connectionFactory == "" && c.options.usesSSL() {
+		params, err := c.sslParams()
+		if err != nil {
+			return err
+		}
+		// the factory copies the parameters
+		defer C.tibemsSSLParams_Destroy(params)
+
+		status = C.tibemsConnectionFactory_SetSSLParams(c.cf, params)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+
+		if c.options.ssl.privateKeyPass != "" {
+			pass := C.CString(c.options.ssl.privateKeyPass)
+			defer C.free(unsafe.Pointer(pass))
+
+			status = C.tibemsConnectionFactory_SetPkPassword(c.cf, pass)
+			if status != TIBEMS_OK {
+				return c.newError(status)
+			}
+		}
+	}
+
+	if c.options.reconnectAttemptCount > 0 {
+		status = C.tibemsConnectionFactory_SetReconnectAttemptCount(c.cf, C.castToInt(C.int(c.options.reconnectAttemptCount)))
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	if c.options.reconnectAttemptDelay > 0 {
+		status = C.tibemsConnectionFactory_SetReconnectAttemptDelay(c.cf, C.castToInt(C.int(c.options.reconnectAttemptDelay)))
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	if c.options.reconnectAttemptTimeout > 0 {
+		status = C.tibemsConnectionFactory_SetReconnectAttemptTimeout(c.cf, C.castToInt(C.int(c.options.reconnectAttemptTimeout)))
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	if c.options.connectAttemptCount > 0 {
+		status = C.tibemsConnectionFactory_SetConnectAttemptCount(c.cf, C.castToInt(C.int(c.options.connectAttemptCount)))
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	if c.options.connectAttemptDelay > 0 {
+		status = C.tibemsConnectionFactory_SetConnectAttemptDelay(c.cf, C.castToInt(C.int(c.options.connectAttemptDelay)))
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+	}
+
+	// the context deadline shortens the configured attempt timeout
+	timeout := c.options.connectAttemptTimeout
+	if remaining, ok := contextTimeout(ctx); ok && (timeout == 0 || remaining < timeout) {
+		timeout = remaining
+	}
+
+	if timeout > 0 {
+		status = C.tibemsConnectionFactory_SetConnectAttemptTimeout(c.cf, C.castToInt(C.int(timeout)))
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
 	}
 
 	// create the connection
-	status = C.tibemsConnectionFactory_CreateConnection(c.cf, &c.conn, C.CString(c.options.username), C.CString(c.options.password))
+	username := C.CString(user)
+	defer C.free(unsafe.Pointer(username))
+
+	password := C.CString(pass)
+	defer C.free(unsafe.Pointer(password))
+
+	status = C.tibemsConnectionFactory_CreateConnection(c.cf, &c.conn, username, password)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		err := c.newError(status)
+		c.options.logf("ems: connect to %s failed: %v", c.options.serverURLString(), err)
+		return err
+	}
+
+	// track disconnects and fault-tolerant reconnects
+	status = c.setExceptionListener()
+	if status != TIBEMS_OK {
+		return c.newError(status)
 	}
 
 	// start the connection
 	status = C.tibemsConnection_Start(c.conn)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		return c.newError(status)
 	}
 
 	c.setConnected(connected)
+	c.options.logf("ems: connected to %s", c.options.serverURLString())
 
 	return nil
 }
@@ -110,409 +296,582 @@ func (c *Client) Disconnect() error {
 	c.RLock()
 	defer c.RUnlock()
 
-	if c.IsConnected() {
+	if c.conn == nil {
+		return nil
+	}
 
-		status := C.tibemsConnection_Stop(c.conn)
-		if status != TIBEMS_OK {
-			return errors.New("failed to stop connection")
-		}
+	// release the cached producers, subscriptions, transactions and browsers before the connection goes away
+	if err := c.closeProducers(); err != nil {
+		return err
+	}
+
+	if err := c.closeReplyQueue(); err != nil {
+		return err
+	}
+
+	if err := c.closeSubscriptions(); err != nil {
+		return err
+	}
+
+	if err := c.closeTransactions(); err != nil {
+		return err
+	}
+
+	if err := c.closeBrowsers(); err != nil {
+		return err
+	}
 
-		// close the connection
-		status = C.tibemsConnection_Close(c.conn)
+	// unacknowledged messages are redelivered once their sessions close
+	c.releaseAcks(nil)
+
+	// a connection the server has dropped can only be closed
+	if atomic.LoadUint32(&c.status) == connected {
+		status := C.tibemsConnection_Stop(c.conn)
 		if status != TIBEMS_OK {
-			return errors.New("failed to close connection")
+			return c.newError(status)
 		}
+	}
 
-		c.setConnected(disconnected)
+	// close the connection
+	status := C.tibemsConnection_Close(c.conn)
+	if status != TIBEMS_OK {
+		return c.newError(status)
 	}
 
+	c.closeLookupContext()
+	c.clearExceptionListener()
+	c.conn = nil
+	c.setConnected(disconnected)
+	c.options.logf("ems: disconnected from %s", c.options.serverURLString())
+
 	return nil
 }
 
-func (c *Client) SendReceive(destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error) {
-	var dest C.tibemsDestination
-	var session C.tibemsSession
-	var requestor C.tibemsMsgRequestor
-	var reqMsg C.tibemsMsg
-	var repMsg C.tibemsMsg
-	var msg C.tibemsTextMsg
-	var destType C.tibemsDestinationType = TIBEMS_QUEUE
+// sslParams creates the SSL parameters from the client options. The caller
+// must destroy them.
+func (c *Client) sslParams() (C.tibemsSSLParams, error) {
 
-	switch strings.ToUpper(destinationType) {
-	case "QUEUE":
-		destType = TIBEMS_QUEUE
-		break
-	case "TOPIC":
-		destType = TIBEMS_TOPIC
-	}
+	o := c.options.ssl
 
-	// create the destination
-	status := C.tibemsDestination_Create(&dest, destType, C.CString(destination))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	params := C.tibemsSSLParams_Create()
+	if params == nil {
+		return nil, newError(TIBEMS_NO_MEMORY, "failed to create SSL parameters", "")
 	}
 
-	// create the session
-	status = C.tibemsConnection_CreateSession(c.conn, &session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	fail := func(status C.tibems_status) (C.tibemsSSLParams, error) {
+		C.tibemsSSLParams_Destroy(params)
+		return nil, c.newError(status)
 	}
 
-	// create the requestor
-	status = C.tibemsMsgRequestor_Create(session, &requestor, dest)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	for _, file := range o.trustedCerts {
+		name := C.CString(file)
+		status := C.tibemsSSLParams_AddTrustedCertFile(params, name, TIBEMS_SSL_ENCODING_AUTO)
+		C.free(unsafe.Pointer(name))
+		if status != TIBEMS_OK {
+			return fail(status)
+		}
 	}
 
-	// create the request message
-	status = C.tibemsMsg_Create(&reqMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	if o.identity != "" {
+		name := C.CString(o.identity)
+		status := C.tibemsSSLParams_SetIdentityFile(params, name, TIBEMS_SSL_ENCODING_AUTO)
+		C.free(unsafe.Pointer(name))
+		if status != TIBEMS_OK {
+			return fail(status)
+		}
 	}
 
-	// create the message
-	status = C.tibemsTextMsg_Create(&msg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	if o.privateKey != "" {
+		name := C.CString(o.privateKey)
+		status := C.tibemsSSLParams_SetPrivateKeyFile(params, name, TIBEMS_SSL_ENCODING_AUTO)
+		C.free(unsafe.Pointer(name))
+		if status != TIBEMS_OK {
+			return fail(status)
+		}
 	}
 
-	// set message delivery mode
-	var emsDeliveryMode = TIBEMS_NON_PERSISTENT
-	if strings.ToLower(deliveryMode) == "persistent" {
-		emsDeliveryMode = TIBEMS_PERSISTENT
-	} else if strings.ToLower(deliveryMode) == "non_persistent" {
-		emsDeliveryMode = TIBEMS_NON_PERSISTENT
-	} else if strings.ToLower(deliveryMode) == "reliable" {
-		emsDeliveryMode = TIBEMS_RELIABLE
+	if o.ciphers != "" {
+		ciphers := C.CString(o.ciphers)
+		status := C.tibemsSSLParams_SetCiphers(params, ciphers)
+		C.free(unsafe.Pointer(ciphers))
+		if status != TIBEMS_OK {
+			return fail(status)
+		}
 	}
 
-	status = C.tibemsMsg_SetDeliveryMode(msg, C.tibemsDeliveryMode(emsDeliveryMode))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	if status := C.tibemsSSLParams_SetVerifyHost(params, C.castToBool(C.int(toBool(!o.noVerifyHost)))); status != TIBEMS_OK {
+		return fail(status)
 	}
 
-	// set message expiration
-	status = C.tibemsMsg_SetExpiration(msg, C.castToLong(C.int(expiration)))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	if status := C.tibemsSSLParams_SetVerifyHostName(params, C.castToBool(C.int(toBool(!o.noVerifyHostName)))); status != TIBEMS_OK {
+		return fail(status)
 	}
 
-	// create the reply message
-	status = C.tibemsMsg_Create(&repMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	if o.expectedHostName != "" {
+		name := C.CString(o.expectedHostName)
+		status := C.tibemsSSLParams_SetExpectedHostName(params, name)
+		C.free(unsafe.Pointer(name))
+		if status != TIBEMS_OK {
+			return fail(status)
+		}
 	}
 
-	// set the message text
-	status = C.tibemsTextMsg_SetText(msg, C.CString(message))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
-	}
+	return params, nil
+}
 
-	// send a request message; wait for a reply
-	status = C.tibemsMsgRequestor_Request(requestor, msg, &repMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
-	}
+func (c *Client) SendReceive(destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error) {
+	return c.SendReceiveContext(context.Background(), destination, destinationType, message, deliveryMode, expiration)
+}
 
-	// Get the string data from the reply text message
+// SendReceiveContext sends a text request and waits for its reply, as
+// RequestContext does, until ctx is done or, if ctx has no deadline, for 30
+// seconds. It returns an error matching ErrTimeout if no reply arrives.
+func (c *Client) SendReceiveContext(ctx context.Context, destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error) {
 
-	var buf *C.char
-	buf = (*C.char)(C.calloc(32768, 1))
-	defer C.free(unsafe.Pointer(buf))
+	reply, timedOut, err := c.RequestContext(ctx, destination, destinationType, NewMessage(message), sendReceiveWait(ctx), deliveryMode, expiration)
+	if err != nil {
+		return "", err
+	}
+	if timedOut {
+		return "", errNoReply
+	}
 
-	status = C.tibemsTextMsg_GetText(repMsg, &buf)
+	return textBody(reply)
+}
 
-	replyMessageText := C.GoString(buf)
+func (c *Client) Receive(destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error) {
+	return c.ReceiveContext(context.Background(), destination, destinationType, timeout, opts...)
+}
 
-	fmt.Println("Received JMS Reply Text Message = " + replyMessageText)
+func (c *Client) ReceiveContext(ctx context.Context, destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error) {
 
-	// destroy the request message
-	status = C.tibemsMsg_Destroy(reqMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
+	msg, timedOut, err := c.ReceiveMessageContext(ctx, destination, destinationType, timeout, opts...)
+	if err != nil || timedOut {
+		return "", timedOut, err
 	}
 
-	// destroy the requestor
-	status = C.tibemsMsgRequestor_Close(requestor)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
-	}
+	body, err := textBody(msg)
+	return body, false, err
+}
 
-	// destroy the session
-	status = C.tibemsSession_Close(session)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
-	}
+func (c *Client) ReceiveMessage(destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error) {
+	return c.ReceiveMessageContext(context.Background(), destination, destinationType, timeout, opts...)
+}
 
-	// destroy the destination
-	status = C.tibemsDestination_Destroy(dest)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", errors.New(e)
-	}
+// ReceiveMessageContext waits up to timeout milliseconds for a message, or
+// until the deadline of ctx if that is sooner. When ctx is done before a
+// message arrives the receive is interrupted and ctx.Err() is returned.
+func (c *Client) ReceiveMessageContext(ctx context.Context, destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error) {
 
-	return replyMessageText, nil
+	if err := ctx.Err(); err != nil {
+		return Message{}, false, err
+	}
 
-}
+	o, err := newConsumerOptions(destinationType, opts)
+	if err != nil {
+		return Message{}, false, err
+	}
 
-func (c *Client) Receive(destination string, destinationType string, timeout int) (string, bool, error) {
+	if remaining, ok := contextTimeout(ctx); ok && (timeout <= 0 || remaining < timeout) {
+		timeout = remaining
+	}
 
 	var dest C.tibemsDestination
 	var session C.tibemsSession
 	var msgConsumer C.tibemsMsgConsumer
 	var msg C.tibemsMsg
-	var msgType C.tibemsMsgType
-	var msgTypeName string
-	var destType C.tibemsDestinationType = TIBEMS_QUEUE
-
-	switch strings.ToUpper(destinationType) {
-	case "QUEUE":
-		destType = TIBEMS_QUEUE
-		break
-	case "TOPIC":
-		destType = TIBEMS_TOPIC
-	}
+	var destType = toDestinationType(destinationType)
 
 	// create the destination
-	status := C.tibemsDestination_Create(&dest, destType, C.CString(destination))
+	name := C.CString(destination)
+	defer C.free(unsafe.Pointer(name))
 
+	status := C.tibemsDestination_Create(&dest, destType, name)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+		return Message{}, false, c.newError(status)
 	}
+	defer C.tibemsDestination_Destroy(dest)
 
-	// create the session
-	status = C.tibemsConnection_CreateSession(c.conn, &session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
+	// create the session, kept open until the message is acknowledged
+	// in the client acknowledge modes
+	status = C.tibemsConnection_CreateSession(c.conn, &session, TIBEMS_FALSE, C.tibemsAcknowledgeMode(o.ackMode))
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+		return Message{}, false, c.newError(status)
 	}
 
+	keep := false
+	defer func() {
+		if !keep {
+			C.tibemsSession_Close(session)
+		}
+	}()
+
 	// create the consumer
-	status = C.tibemsSession_CreateConsumer(session, &msgConsumer, dest, nil, TIBEMS_FALSE)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+	msgConsumer, err = c.createConsumer(session, dest, o)
+	if err != nil {
+		return Message{}, false, err
 	}
 
 	// start the connection
 	status = C.tibemsConnection_Start(c.conn)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+		return Message{}, false, c.newError(status)
 	}
 
+	// closing the consumer interrupts the receive when the context is done
+	stop := interruptOnDone(ctx, func() {
+		C.tibemsMsgConsumer_Close(msgConsumer)
+	})
+
 	status = C.tibemsMsgConsumer_ReceiveTimeout(msgConsumer, &msg, C.castToLong(C.int(timeout)))
+	consumerOpen := !stop()
+
+	if status != TIBEMS_OK && ctx.Err() != nil {
+		return Message{}, false, ctx.Err()
+	}
 
 	if status != TIBEMS_OK {
 		if status == TIBEMS_TIMEOUT {
-			return "", true, nil
+			return Message{}, true, nil
 		} else {
-			e, s := c.getErrorContext()
-			fmt.Println(s)
-			return "", false, errors.New(e)
+			return Message{}, false, c.newError(status)
 		}
 	}
 
-	// Check message type
-	status = C.tibemsMsg_GetBodyType(msg, &msgType)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
-	}
-
-	switch msgType {
-	case TIBEMS_MESSAGE:
-		msgTypeName = "MESSAGE"
-		break
-	case TIBEMS_TEXT_MESSAGE:
-		msgTypeName = "TEXT"
-		break
-	case TIBEMS_BYTES_MESSAGE:
-		msgTypeName = "BYTES"
-		break
-	case TIBEMS_OBJECT_MESSAGE:
-		msgTypeName = "OBJECT"
-		break
-	case TIBEMS_STREAM_MESSAGE:
-		msgTypeName = "STREAM"
-		break
-	case TIBEMS_MAP_MESSAGE:
-		msgTypeName = "MAP"
-		break
-	default:
-		msgTypeName = "UNKNOWN"
-		break
+	m, err := c.readMsg(msg)
+	if err != nil || !o.clientAcknowledge() {
+		C.tibemsMsg_Destroy(msg)
+		return m, false, err
 	}
 
-	if msgType != TIBEMS_TEXT_MESSAGE {
-		return "", false, errors.New("Unable to process message type " + msgTypeName)
+	// close the consumer so it holds no further messages while the
+	// session waits for the acknowledgement
+	if consumerOpen {
+		C.tibemsMsgConsumer_Close(msgConsumer)
 	}
 
-	// Get the string data from the reply text message
+	keep = true
+	m.acker = c.newAck(msg, session, true)
 
-	var buf *C.char
-	buf = (*C.char)(C.calloc(32768, 1))
-	defer C.free(unsafe.Pointer(buf))
+	return m, false, nil
+}
 
-	status = C.tibemsTextMsg_GetText(msg, &buf)
+func (c *Client) Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+	return c.SendMessageContext(context.Background(), destination, destinationType, NewMessage(message), deliveryDelay, deliveryMode, expiration)
+}
 
-	messageText := C.GoString(buf)
+func (c *Client) SendContext(ctx context.Context, destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+	return c.SendMessageContext(ctx, destination, destinationType, NewMessage(message), deliveryDelay, deliveryMode, expiration)
+}
 
-	//fmt.Println("Received JMS Text Message = "+ messageText)
+func (c *Client) SendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+	return c.SendMessageContext(context.Background(), destination, destinationType, message, deliveryDelay, deliveryMode, expiration)
+}
 
-	// destroy the message
-	status = C.tibemsMsg_Destroy(msg)
+// SendMessageContext sends the message, returning ctx.Err() if ctx is done
+// before the server accepts it. A send that has already been handed to EMS
+// is not recalled and may still be delivered.
+func (c *Client) SendMessageContext(ctx context.Context, destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctx.Done() == nil {
+		return c.sendMessage(destination, destinationType, message, deliveryDelay, deliveryMode, expiration)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.sendMessage(destination, destinationType, message, deliveryDelay, deliveryMode, expiration)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) sendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	// create the message
+	msg, release, err := c.newMsg(message)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return c.sendMsg(destination, destinationType, msg, message.Priority, deliveryDelay, deliveryMode, expiration)
+}
+
+// sendMsg sends a prepared message with the cached producer for the destination.
+func (c *Client) sendMsg(destination string, destinationType string, msg C.tibemsMsg, priority int, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	p, err := c.lockProducer(destination, destinationType)
+	if err != nil {
+		return err
+	}
+	defer p.Unlock()
+
+	err = c.send(p.producer, msg, priority, deliveryDelay, deliveryMode, expiration)
+	if err != nil {
+		// don't reuse a producer the server has rejected
+		c.closeProducer(p)
+	}
+
+	return err
+}
+
+// send sets the delivery options on the producer and sends the message.
+func (c *Client) send(msgProducer C.tibemsMsgProducer, msg C.tibemsMsg, priority int, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	if err := c.setProducerOptions(msgProducer, priority, deliveryDelay, deliveryMode, expiration); err != nil {
+		return err
+	}
+
+	// publish the message
+	status := C.tibemsMsgProducer_Send(msgProducer, msg)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+		return c.newError(status)
 	}
 
-	// destroy the session
-	status = C.tibemsSession_Close(session)
+	return nil
+}
+
+// setProducerOptions sets the delivery options of the next send.
+func (c *Client) setProducerOptions(msgProducer C.tibemsMsgProducer, priority int, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	status := C.tibemsMsgProducer_SetDeliveryDelay(msgProducer, C.castToLong(C.int(deliveryDelay)))
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+		return c.newError(status)
 	}
 
-	// destroy the destination
-	status = C.tibemsDestination_Destroy(dest)
+	status = C.tibemsMsgProducer_SetDeliveryMode(msgProducer, C.castToInt(C.int(toDeliveryMode(deliveryMode))))
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return "", false, errors.New(e)
+		return c.newError(status)
 	}
 
-	return messageText, false, nil
+	status = C.tibemsMsgProducer_SetTimeToLive(msgProducer, C.castToLong(C.int(expiration)))
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	// the producer's priority overrides the one set on the message
+	if priority == 0 {
+		priority = DefaultPriority
+	}
+	status = C.tibemsMsgProducer_SetPriority(msgProducer, C.castToInt(C.int(priority)))
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
 }
 
-func (c *Client) Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+// lockProducer returns the locked producer for the destination. A producer
+// closed while waiting for the lock, by a failed send or by Disconnect, is
+// replaced once before giving up.
+func (c *Client) lockProducer(destination string, destinationType string) (*producer, error) {
+
+	for attempt := 0; attempt < 2; attempt++ {
+		p, err := c.getProducer(destination, destinationType)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Lock()
+		if !p.closed {
+			return p, nil
+		}
+		p.Unlock()
+	}
+
+	return nil, newError(TIBEMS_ILLEGAL_STATE, "producer is closed", "")
+}
+
+func (c *Client) getProducer(destination string, destinationType string) (*producer, error) {
 
 	var dest C.tibemsDestination
 	var session C.tibemsSession
 	var msgProducer C.tibemsMsgProducer
-	var txtMsg C.tibemsTextMsg
-	var destType C.tibemsDestinationType = TIBEMS_QUEUE
 
-	switch strings.ToUpper(destinationType) {
-	case "QUEUE":
-		destType = TIBEMS_QUEUE
-		break
-	case "TOPIC":
-		destType = TIBEMS_TOPIC
+	destType := toDestinationType(destinationType)
+	key := producerKey(destination, destType)
+
+	c.producersLock.Lock()
+	defer c.producersLock.Unlock()
+
+	if p, ok := c.producers[key]; ok {
+		return p, nil
 	}
 
 	// create the destination
-	status := C.tibemsDestination_Create(&dest, destType, C.CString(destination))
+	name := C.CString(destination)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsDestination_Create(&dest, destType, name)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		return nil, c.newError(status)
 	}
 
 	// create the session
 	status = C.tibemsConnection_CreateSession(c.conn, &session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		err := c.newError(status)
+		C.tibemsDestination_Destroy(dest)
+		return nil, err
 	}
 
 	// create the producer
 	status = C.tibemsSession_CreateProducer(session, &msgProducer, dest)
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		err := c.newError(status)
+		C.tibemsSession_Close(session)
+		C.tibemsDestination_Destroy(dest)
+		return nil, err
 	}
 
-	status = C.tibemsMsgProducer_SetDeliveryDelay(msgProducer, C.castToLong(C.int(deliveryDelay)))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+	p := &producer{
+		key:      key,
+		dest:     dest,
+		session:  session,
+		producer: msgProducer,
 	}
 
-	var emsDeliveryMode = TIBEMS_NON_PERSISTENT
-	if strings.ToLower(deliveryMode) == "persistent" {
-		emsDeliveryMode = TIBEMS_PERSISTENT
-	} else if strings.ToLower(deliveryMode) == "non_persistent" {
-		emsDeliveryMode = TIBEMS_NON_PERSISTENT
-	} else if strings.ToLower(deliveryMode) == "reliable" {
-		emsDeliveryMode = TIBEMS_RELIABLE
+	if c.producers == nil {
+		c.producers = make(map[string]*producer)
 	}
+	c.producers[key] = p
 
-	status = C.tibemsMsgProducer_SetDeliveryMode(msgProducer, C.castToInt(C.int(emsDeliveryMode)))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
-	}
+	return p, nil
+}
 
-	status = C.tibemsMsgProducer_SetTimeToLive(msgProducer, C.castToLong(C.int(expiration)))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
-	}
+// closeProducer removes the producer from the cache and releases its EMS resources.
+func (c *Client) closeProducer(p *producer) {
 
-	// create the message
-	status = C.tibemsTextMsg_Create(&txtMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+	c.producersLock.Lock()
+	if c.producers[p.key] == p {
+		delete(c.producers, p.key)
 	}
+	c.producersLock.Unlock()
 
-	// set the message text
-	status = C.tibemsTextMsg_SetText(txtMsg, C.CString(message))
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
-	}
+	p.close()
+}
 
-	// publish the message
-	status = C.tibemsMsgProducer_Send(msgProducer, txtMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+// closeProducers releases every cached producer.
+func (c *Client) closeProducers() error {
+
+	c.producersLock.Lock()
+	producers := c.producers
+	c.producers = nil
+	c.producersLock.Unlock()
+
+	var err error
+	for _, p := range producers {
+		p.Lock()
+		if e := p.close(); e != nil && err == nil {
+			err = e
+		}
+		p.Unlock()
 	}
 
-	// destroy the message
-	status = C.tibemsMsg_Destroy(txtMsg)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+	return err
+}
+
+func (p *producer) close() error {
+
+	var err error
+
+	if p.closed {
+		return nil
 	}
+	p.closed = true
 
 	// destroy the producer
-	status = C.tibemsMsgProducer_Close(msgProducer)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+	if status := C.tibemsMsgProducer_Close(p.producer); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close producer", "")
 	}
 
 	// destroy the session
-	status = C.tibemsSession_Close(session)
-	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+	if status := C.tibemsSession_Close(p.session); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close session", "")
 	}
 
 	// destroy the destination
-	status = C.tibemsDestination_Destroy(dest)
+	if status := C.tibemsDestination_Destroy(p.dest); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to destroy destination", "")
+	}
+
+	return err
+}
+
+// createConsumer creates the consumer, durable subscriber or shared
+// consumer described by o on the session.
+func (c *Client) createConsumer(session C.tibemsSession, dest C.tibemsDestination, o *consumerOptions) (C.tibemsMsgConsumer, error) {
+
+	var consumer C.tibemsMsgConsumer
+	var status C.tibems_status
+	var msgSelector, name *C.char
+
+	if o.selector != "" {
+		msgSelector = C.CString(o.selector)
+		defer C.free(unsafe.Pointer(msgSelector))
+	}
+
+	if o.subscription != "" {
+		name = C.CString(o.subscription)
+		defer C.free(unsafe.Pointer(name))
+	}
+
+	switch {
+	case o.shared && o.durable:
+		status = C.tibemsSession_CreateSharedDurableConsumer(session, &consumer, dest, name, msgSelector)
+	case o.shared:
+		status = C.tibemsSession_CreateSharedConsumer(session, &consumer, dest, name, msgSelector)
+	case o.durable:
+		status = C.tibemsSession_CreateDurableSubscriber(session, &consumer, dest, name, msgSelector, TIBEMS_FALSE)
+	default:
+		status = C.tibemsSession_CreateConsumer(session, &consumer, dest, msgSelector, TIBEMS_FALSE)
+	}
+
 	if status != TIBEMS_OK {
-		e, _ := c.getErrorContext()
-		return errors.New(e)
+		return nil, c.newError(status)
 	}
 
-	return nil
+	return consumer, nil
+}
+
+func producerKey(destination string, destType C.tibemsDestinationType) string {
+	return fmt.Sprintf("%d:%s", int(destType), destination)
+}
+
+func toDestinationType(destinationType string) C.tibemsDestinationType {
+	switch strings.ToUpper(destinationType) {
+	case "TOPIC":
+		return TIBEMS_TOPIC
+	default:
+		return TIBEMS_QUEUE
+	}
+}
+
+func toBool(b bool) int {
+	if b {
+		return TIBEMS_TRUE
+	}
+	return TIBEMS_FALSE
+}
+
+func toDeliveryMode(deliveryMode string) int {
+	switch strings.ToLower(deliveryMode) {
+	case "persistent":
+		return TIBEMS_PERSISTENT
+	case "reliable":
+		return TIBEMS_RELIABLE
+	default:
+		return TIBEMS_NON_PERSISTENT
+	}
 }
 
 func (c *Client) connectionStatus() uint32 {
@@ -528,6 +887,12 @@ func (c *Client) setConnected(status uint32) {
 	atomic.StoreUint32(&c.status, status)
 }
 
+// newError returns an *Error for the status, filled in from the error context.
+func (c *Client) newError(status C.tibems_status) error {
+	e, s := c.getErrorContext()
+	return newError(int(status), e, s)
+}
+
 func (c *Client) getErrorContext() (string, string) {
 
 	var errorString, stackTrace = "", ""
@@ -0,0 +1,29 @@
+//go:build !tibems
+
+package ems
+
+import "context"
+
+// errNoTibems is returned by Connect in builds without the tibems tag.
+var errNoTibems = newError(TIBEMS_NOT_IMPLEMENTED, "built without the tibems tag; build with -tags tibems, or use NewMemoryClient for the in-memory client", "")
+
+// NewClient returns a client whose Connect fails, as the EMS C library is
+// only used when building with the tibems tag. Every other call fails as the
+// client is not connected.
+func NewClient(o *ClientOptions) IClient {
+	return &stubClient{IClient: NewMemoryClient(o)}
+}
+
+// stubClient stops a build without the tibems tag from silently using the
+// in-memory broker in place of an EMS server.
+type stubClient struct {
+	IClient
+}
+
+func (c *stubClient) Connect() error {
+	return errNoTibems
+}
+
+func (c *stubClient) ConnectContext(ctx context.Context) error {
+	return errNoTibems
+}
@@ -0,0 +1,21 @@
+//go:build !tibems
+
+package ems
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewClient_WithoutTibems(t *testing.T) {
+
+	c := NewClient(NewClientOptions().SetServerUrl("tcp://stub:7222"))
+
+	if err := c.Connect(); !errors.Is(err, errNoTibems) {
+		t.Fatalf("expected connect to fail without the tibems tag, got %v", err)
+	}
+
+	if err := c.Send("queue.sample", "queue", "hello", 0, "non_persistent", 0); err == nil {
+		t.Fatalf("expected send to fail")
+	}
+}
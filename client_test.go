@@ -1,8 +1,13 @@
+//go:build tibems
+
 package ems
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestNewClient(t *testing.T) {
@@ -41,7 +46,7 @@ func TestClient_Connect(t *testing.T) {
 
 	err := c.Connect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	c.Disconnect()
@@ -56,17 +61,17 @@ func TestClient_Send(t *testing.T) {
 
 	err := c.Connect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	err = c.Send("queue.sample", "queue", "hello, world", 0, "non_persistent", 10000)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	err = c.Disconnect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 }
 
@@ -78,17 +83,17 @@ func TestClient_SendReceive(t *testing.T) {
 
 	err := c.Connect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	_, err = c.SendReceive("queue.sample", "queue", "hello, world", "non_persistent", 1000)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	err = c.Disconnect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 }
@@ -101,13 +106,13 @@ func TestClient_Receive(t *testing.T) {
 
 	err := c.Connect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	msg, timeout, err := c.Receive("queue.sample", "queue", 1000)
 
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	if timeout {
@@ -118,7 +123,745 @@ func TestClient_Receive(t *testing.T) {
 
 	err = c.Disconnect()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
+	}
+
+}
+
+func TestClient_SendMessage(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewMessage("hello, world")
+	msg.CorrelationID = "correlation-1"
+	msg.Type = "greeting"
+	msg.SetProperty("count", int32(1))
+	msg.SetProperty("origin", "client_test")
+
+	err = c.SendMessage("queue.sample", "queue", msg, 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_SendMessageLiteral(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SendMessage("queue.literal", "queue", Message{Body: "literal"}, 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, err := c.ReceiveMessage("queue.literal", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || msg.Priority != DefaultPriority {
+		t.Fatalf("expected the literal at priority %d, got %d", DefaultPriority, msg.Priority)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ReceiveMessage(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, err := c.ReceiveMessage("queue.sample", "queue", 1000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		fmt.Println("Timeout detected")
+	}
+
+	fmt.Println(msg.Body, msg.CorrelationID, msg.Properties)
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestClient_Subscribe(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan Message, 1)
+
+	sub, err := c.Subscribe("queue.subscribe", "queue", func(msg Message) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send("queue.subscribe", "queue", "hello, world", 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Body != "hello, world" {
+			t.Fatalf("bad message body")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message received")
+	}
+
+	err = sub.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ReceiveContext(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	_, _, err = c.ReceiveContext(ctx, "queue.empty", "queue", 0)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_SendBytes(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SendMessage("queue.bytes", "queue", NewBytesMessage([]byte{0, 1, 2, 255}), 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, err := c.ReceiveMessage("queue.bytes", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		t.Fatalf("no message received")
+	}
+
+	if msg.BodyType != BodyTypeBytes || len(msg.Bytes) != 4 {
+		t.Fatalf("bad bytes message")
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_SendMap(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]interface{}{
+		"name":  "order",
+		"count": int32(3),
+		"raw":   []byte{1, 2},
+		"nested": map[string]interface{}{
+			"flag": true,
+		},
+	}
+
+	err = c.SendMessage("queue.map", "queue", NewMapMessage(fields), 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, err := c.ReceiveMessage("queue.map", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		t.Fatalf("no message received")
+	}
+
+	nested, _ := msg.Map["nested"].(map[string]interface{})
+	if msg.BodyType != BodyTypeMap || msg.Map["count"] != int32(3) || nested["flag"] != true {
+		t.Fatalf("bad map message %+v", msg)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_SendStream(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	err = c.SendMessage("queue.stream", "queue", NewStreamMessage(int32(1), "two", []byte{3}), 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, err := c.ReceiveMessage("queue.stream", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		t.Fatalf("no message received")
+	}
+
+	r := NewStreamReader(msg)
+	if n, err := r.ReadInt32(); err != nil || n != 1 {
+		t.Fatalf("bad stream message %+v", msg)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ReceiveSelector(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewMessage("selected")
+	msg.SetProperty("color", "blue")
+
+	err = c.SendMessage("queue.selector", "queue", msg, 0, "non_persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, timeout, err := c.Receive("queue.selector", "queue", 1000, WithSelector("color = 'blue'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || body != "selected" {
+		t.Fatalf("selected message not received")
+	}
+
+	_, _, err = c.Receive("queue.selector", "queue", 1000, WithSelector("color ="))
+	if !errors.Is(err, ErrInvalidSelector) {
+		t.Fatalf("expected ErrInvalidSelector, got %v", err)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_DurableSubscription(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("").SetClientID("go-ems-test")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = c.Receive("topic.durable", "topic", 10, WithDurable("durable-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send("topic.durable", "topic", "kept", 0, "persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, timeout, err := c.Receive("topic.durable", "topic", 1000, WithDurable("durable-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || body != "kept" {
+		t.Fatalf("durable subscription lost the message")
+	}
+
+	err = c.Unsubscribe("durable-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ClientAcknowledge(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send("queue.ack", "queue", "ack me", 0, "persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, err := c.ReceiveMessage("queue.ack", "queue", 1000, WithAcknowledgeMode(TIBEMS_CLIENT_ACKNOWLEDGE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		t.Fatalf("no message received")
+	}
+
+	err = msg.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _, err = c.ReceiveMessage("queue.ack", "queue", 1000, WithAcknowledgeMode(TIBEMS_CLIENT_ACKNOWLEDGE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !msg.Redelivered {
+		t.Fatalf("recovered message not redelivered")
+	}
+
+	err = msg.Ack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_SubscriptionAcknowledge(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan Message, 1)
+
+	sub, err := c.Subscribe("queue.subscribe.ack", "queue", func(msg Message) {
+		received <- msg
+	}, WithAcknowledgeMode(TIBEMS_CLIENT_ACKNOWLEDGE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send("queue.subscribe.ack", "queue", "ack me", 0, "persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	select {
+	case msg = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no message received")
+	}
+
+	err = sub.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription's session is closed
+	if err := msg.Ack(); !errors.Is(err, ErrIllegalState) {
+		t.Fatalf("expected illegal state, got %v", err)
+	}
+
+	if len(c.acks) != 0 {
+		t.Fatalf("subscription left %d pending acks", len(c.acks))
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Transaction(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send("queue.tx.in", "queue", "forward me", 0, "persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := c.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, timeout, err := tx.Receive("queue.tx.in", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		t.Fatalf("no message received")
+	}
+
+	err = tx.Send("queue.tx.out", "queue", body, 0, "persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, timeout, err = c.Receive("queue.tx.out", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || body != "forward me" {
+		t.Fatalf("committed message not delivered")
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Request(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := c.Subscribe("queue.request", "queue", func(req Message) {
+		reply := NewMessage("re: " + req.Body)
+		reply.CorrelationID = req.CorrelationID
+		c.SendMessage(req.ReplyTo, req.ReplyToType, reply, 0, "non_persistent", 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, timeout, err := c.Request("queue.request", "queue", NewMessage("ping"), 5000, "non_persistent", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || reply.Body != "re: ping" {
+		t.Fatalf("bad reply %q, timeout %v", reply.Body, timeout)
+	}
+
+	_, timeout, err = c.Request("queue.request.none", "queue", NewMessage("ping"), 100, "non_persistent", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !timeout {
+		t.Fatalf("expected timeout")
+	}
+
+	sub.Close()
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Serve(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := c.Serve("queue.rpc", func(req Message) (Message, error) {
+		if req.Body == "fail" {
+			return Message{}, errors.New("bad request")
+		}
+		return NewMessage("re: " + req.Body), nil
+	}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, timeout, err := c.Request("queue.rpc", "queue", NewMessage("ping"), 5000, "non_persistent", 0)
+	if err != nil || timeout {
+		t.Fatalf("request failed: %v %v", timeout, err)
+	}
+
+	if reply.Body != "re: ping" {
+		t.Fatalf("bad reply %q", reply.Body)
+	}
+
+	reply, timeout, err = c.Request("queue.rpc", "queue", NewMessage("fail"), 5000, "non_persistent", 0)
+	if err != nil || timeout {
+		t.Fatalf("request failed: %v %v", timeout, err)
+	}
+
+	if reply.Properties[ReplyStatusProperty] != ReplyStatusError {
+		t.Fatalf("expected error reply, got %v", reply.Properties)
+	}
+
+	// replies to the temporary reply queue don't leave a cached producer behind
+	if len(c.producers) != 1 {
+		t.Fatalf("expected only the request producer to be cached, got %d", len(c.producers))
+	}
+
+	srv.Close()
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Browse(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Send("queue.browse", "queue", "browse me", 0, "persistent", 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.Browse("queue.browse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok, err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || m.Body != "browse me" {
+		t.Fatalf("bad browsed message %q", m.Body)
+	}
+
+	b.Close()
+
+	body, timeout, err := c.Receive("queue.browse", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || body != "browse me" {
+		t.Fatalf("browsed message was consumed")
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ConnectionFactoryLookup(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("").
+		SetConnectionFactoryName("QueueConnectionFactory")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest, destType, err := c.LookupDestination("sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dest != "sample" || destType != "queue" {
+		t.Fatalf("bad destination %s %s", dest, destType)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Metadata(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("").SetClientID("metadata-test")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := c.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ClientID != "metadata-test" || m.ActiveURL == "" || m.ProviderVersion == "" {
+		t.Fatalf("bad metadata %+v", m)
+	}
+
+	err = c.Disconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkClient_Send measures sends through the cached producer, which
+// avoids creating a session and producer per message.
+func BenchmarkClient_Send(b *testing.B) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://127.0.0.1:7222").SetUsername("admin").SetPassword("")
+
+	c := NewClient(ops).(*Client)
+
+	err := c.Connect()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = c.Send("queue.benchmark", "queue", "hello, world", 0, "non_persistent", 10000)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
 }
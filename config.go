@@ -0,0 +1,641 @@
+package ems
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configKeys lists the settings read by LoadClientOptions. Files use these
+// names, with the ssl_ settings optionally in an ssl or tls section, and the
+// environment variables are EMS_ followed by the upper-case name, e.g.
+// EMS_URL or EMS_SSL_TRUSTED_CERTS. EMS_TLS_ works as well as EMS_SSL_.
+var configKeys = []string{
+	"url",
+	"user",
+	"password",
+	"password_file",
+	"client_id",
+	"connection_factory",
+	"connect_attempt_count",
+	"connect_attempt_delay",
+	"connect_attempt_timeout",
+	"reconnect_attempt_count",
+	"reconnect_attempt_delay",
+	"reconnect_attempt_timeout",
+	"ssl_trusted_certs",
+	"ssl_cert",
+	"ssl_key",
+	"ssl_key_password",
+	"ssl_key_password_file",
+	"ssl_ciphers",
+	"ssl_verify_host",
+	"ssl_verify_host_name",
+	"ssl_expected_host_name",
+}
+
+// secretKeys are the settings that may instead be read from the file named
+// by the setting with a _file suffix.
+var secretKeys = []string{"password", "ssl_key_password"}
+
+// pathKeys are the settings naming files. Relative names in a configuration
+// file are relative to the directory of that file.
+var pathKeys = []string{"password_file", "ssl_key_password_file", "ssl_trusted_certs", "ssl_cert", "ssl_key"}
+
+// LoadClientOptions reads client options from a JSON, YAML or TOML file,
+// chosen by the file extension, and then from EMS_ environment variables,
+// which take precedence. path may be empty to read the environment only.
+//
+// A secret such as password may be given directly or as password_file, the
+// name of a file holding it. Setting both in one source is an error; a
+// higher precedence source replaces either form. File names in a
+// configuration file, including the SSL certificates and key, are relative
+// to the directory of the configuration file.
+//
+// Files hold one level of sections at most. YAML files may use key: value
+// pairs, plain or quoted scalars, and lists as [a, b] or indented "- item"
+// lines; TOML files may use key = value pairs with single line strings,
+// numbers, booleans and arrays, and [section] tables. Other constructs, such
+// as YAML block scalars or TOML multi-line strings, are rejected with the
+// line number.
+//
+// The options are not validated; NewClientWithOptions(WithClientOptions(o))
+// validates them.
+func LoadClientOptions(path string) (*ClientOptions, error) {
+
+	settings := make(map[string]string)
+
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeSettings(settings, file, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mergeSettings(settings, envSettings(os.LookupEnv), "environment"); err != nil {
+		return nil, err
+	}
+
+	return settingsToOptions(settings)
+}
+
+// ClientOptionsFromEnv reads client options from EMS_ environment variables only.
+func ClientOptionsFromEnv() (*ClientOptions, error) {
+	return LoadClientOptions("")
+}
+
+// WithClientOptions starts from a copy of o, such as options returned by
+// LoadClientOptions. Options after it override its settings.
+func WithClientOptions(o *ClientOptions) ClientOption {
+	return func(dst *ClientOptions) {
+		*dst = *o
+	}
+}
+
+// envSettings returns the settings found in the environment.
+func envSettings(lookup func(string) (string, bool)) map[string]string {
+
+	settings := make(map[string]string)
+
+	for _, key := range configKeys {
+		name := "EMS_" + strings.ToUpper(key)
+		if v, ok := lookup(name); ok {
+			settings[key] = v
+			continue
+		}
+		if strings.HasPrefix(key, "ssl_") {
+			if v, ok := lookup("EMS_TLS_" + strings.ToUpper(strings.TrimPrefix(key, "ssl_"))); ok {
+				settings[key] = v
+			}
+		}
+	}
+
+	return settings
+}
+
+// mergeSettings copies src over dst. A secret set in src replaces both forms
+// of the secret in dst.
+func mergeSettings(dst map[string]string, src map[string]string, source string) error {
+
+	for _, key := range secretKeys {
+		_, value := src[key]
+		_, file := src[key+"_file"]
+		if value && file {
+			return fmt.Errorf("%s sets both %s and %s_file", source, key, key)
+		}
+		if value || file {
+			delete(dst, key)
+			delete(dst, key+"_file")
+		}
+	}
+
+	for k, v := range src {
+		dst[k] = v
+	}
+
+	return nil
+}
+
+// settingsToOptions applies the settings to new ClientOptions, reading
+// secrets from their files.
+func settingsToOptions(settings map[string]string) (*ClientOptions, error) {
+
+	o := NewClientOptions()
+
+	for _, key := range secretKeys {
+		name, ok := settings[key+"_file"]
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s_file: %v", key, err)
+		}
+		settings[key] = strings.TrimRight(string(data), "\r\n")
+	}
+
+	integer := func(key string, set func(int) *ClientOptions) error {
+		v, ok := settings[key]
+		if !ok {
+			return nil
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: must be an integer", key, v)
+		}
+		set(n)
+		return nil
+	}
+
+	boolean := func(key string, set func(bool) *ClientOptions) error {
+		v, ok := settings[key]
+		if !ok {
+			return nil
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: must be true or false", key, v)
+		}
+		set(b)
+		return nil
+	}
+
+	if v, ok := settings["url"]; ok {
+		o.SetServerUrl(v)
+	}
+	o.SetUsername(settings["user"])
+	o.SetPassword(settings["password"])
+	o.SetClientID(settings["client_id"])
+	o.SetConnectionFactoryName(settings["connection_factory"])
+
+	for _, err := range []error{
+		integer("connect_attempt_count", o.SetConnectAttemptCount),
+		integer("connect_attempt_delay", o.SetConnectAttemptDelay),
+		integer("connect_attempt_timeout", o.SetConnectAttemptTimeout),
+		integer("reconnect_attempt_count", o.SetReconnectAttemptCount),
+		integer("reconnect_attempt_delay", o.SetReconnectAttemptDelay),
+		integer("reconnect_attempt_timeout", o.SetReconnectAttemptTimeout),
+		boolean("ssl_verify_host", o.SetSSLVerifyHost),
+		boolean("ssl_verify_host_name", o.SetSSLVerifyHostName),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := settings["ssl_trusted_certs"]; ok {
+		var files []string
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				files = append(files, f)
+			}
+		}
+		o.SetSSLTrustedCertificates(files...)
+	}
+	o.SetSSLIdentity(settings["ssl_cert"], settings["ssl_key"])
+	o.SetSSLPrivateKeyPassword(settings["ssl_key_password"])
+	o.SetSSLCiphers(settings["ssl_ciphers"])
+	o.SetSSLExpectedHostName(settings["ssl_expected_host_name"])
+
+	return o, nil
+}
+
+// readConfigFile parses a configuration file into settings, flattening
+// sections into prefixed names and lists into comma separated values.
+func readConfigFile(path string) (map[string]string, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJSONConfig(data)
+	case ".yaml", ".yml":
+		values, err = parseYAMLConfig(data)
+	case ".toml":
+		values, err = parseTOMLConfig(data)
+	default:
+		return nil, fmt.Errorf("unsupported configuration file type %q, expected .json, .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	settings := make(map[string]string, len(values))
+	for k, v := range values {
+		key := configKey(k)
+		if !isConfigKey(key) {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, k)
+		}
+		settings[key] = v
+	}
+
+	dir := filepath.Dir(path)
+	for _, key := range pathKeys {
+		v, ok := settings[key]
+		if !ok || v == "" {
+			continue
+		}
+		names := strings.Split(v, ",")
+		for i, name := range names {
+			name = strings.TrimSpace(name)
+			if name != "" && !filepath.IsAbs(name) {
+				name = filepath.Join(dir, name)
+			}
+			names[i] = name
+		}
+		settings[key] = strings.Join(names, ",")
+	}
+
+	return settings, nil
+}
+
+// configKey normalises a setting name: lower case, underscores for dashes,
+// and the tls section read as ssl.
+func configKey(name string) string {
+
+	key := strings.ReplaceAll(strings.ToLower(name), "-", "_")
+	if strings.HasPrefix(key, "tls_") {
+		key = "ssl_" + strings.TrimPrefix(key, "tls_")
+	}
+	if key == "username" {
+		key = "user"
+	}
+
+	return key
+}
+
+func isConfigKey(key string) bool {
+	for _, k := range configKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func parseJSONConfig(data []byte) (map[string]string, error) {
+
+	// numbers are kept as written, as float64 would print 1000000 as 1e+06
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+
+	values := make(map[string]string)
+
+	var flatten func(section string, m map[string]interface{}) error
+	flatten = func(section string, m map[string]interface{}) error {
+		for k, v := range m {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if section != "" {
+					return fmt.Errorf("section %q is nested too deeply", k)
+				}
+				if err := flatten(k, v); err != nil {
+					return err
+				}
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					items[i] = fmt.Sprint(item)
+				}
+				values[qualify(section, k)] = strings.Join(items, ",")
+			case nil:
+			default:
+				values[qualify(section, k)] = fmt.Sprint(v)
+			}
+		}
+		return nil
+	}
+
+	if err := flatten("", doc); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// parseYAMLConfig parses the YAML subset used by configuration files:
+// key: value pairs, one level of sections, and lists as [a, b] or as
+// indented "- item" lines. Anything else, such as block scalars, deeper
+// nesting, anchors or flow mappings, is rejected.
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+
+	values := make(map[string]string)
+
+	section, last := "", ""
+	var list []string
+	keyIndent := 0 // indentation of the keys in the current section
+	listKey := 0   // line of an indented key waiting for its list items
+
+	flush := func() error {
+		if list != nil {
+			values[last] = strings.Join(list, ",")
+			list = nil
+		} else if listKey > 0 {
+			return fmt.Errorf("line %d: nested sections are not supported", listKey)
+		}
+		listKey = 0
+		return nil
+	}
+
+	for n, line := range strings.Split(string(data), "\n") {
+
+		text := strings.TrimRight(stripComment(line), " \t\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		indent := len(text) - len(strings.TrimLeft(text, " \t"))
+		indented := indent > 0
+		text = strings.TrimSpace(text)
+
+		if strings.HasPrefix(text, "- ") || text == "-" {
+			if last == "" || !indented {
+				return nil, fmt.Errorf("line %d: list item without a key", n+1)
+			}
+			item, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(text, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			list = append(list, item)
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+
+		// a key ends at a colon followed by a space or the end of the line
+		i := strings.Index(text, ": ")
+		if i < 0 && strings.HasSuffix(text, ":") {
+			i = len(text) - 1
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key: value", n+1)
+		}
+		key, value := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+
+		if !indented {
+			section, keyIndent = "", 0
+		} else if section == "" {
+			return nil, fmt.Errorf("line %d: unexpected indentation", n+1)
+		} else if keyIndent == 0 {
+			keyIndent = indent
+		} else if indent != keyIndent {
+			return nil, fmt.Errorf("line %d: nested sections are not supported", n+1)
+		}
+
+		if value == "" {
+			// a section, or a key whose list items follow
+			if !indented {
+				section = key
+			} else {
+				listKey = n + 1
+			}
+			last = qualify(section, key)
+			continue
+		}
+
+		if strings.ContainsAny(value[:1], "|>{&*!") {
+			return nil, fmt.Errorf("line %d: unsupported value %s, only plain or quoted scalars and [a, b] lists are supported", n+1, value)
+		}
+
+		last = qualify(section, key)
+		if strings.HasPrefix(value, "[") {
+			items, err := inlineList(value, yamlScalar)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			values[last] = items
+			continue
+		}
+
+		v, err := yamlScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		values[last] = v
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// qualify prefixes a key inside a section with the section name, unless the
+// key already has it, as ssl_ciphers in an ssl or tls section does.
+func qualify(section string, key string) string {
+	if section == "" || section == key || strings.HasPrefix(configKey(key), configKey(section+"_")) {
+		return key
+	}
+	return section + "_" + key
+}
+
+func yamlScalar(s string) (string, error) {
+
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+
+	return s, nil
+}
+
+// parseTOMLConfig parses the TOML subset used by configuration files:
+// key = value pairs with single line strings, numbers, booleans and single
+// line arrays, and [section] tables. Anything else, such as multi-line
+// strings and arrays, dotted keys or nested tables, is rejected.
+func parseTOMLConfig(data []byte) (map[string]string, error) {
+
+	values := make(map[string]string)
+	section := ""
+
+	for n, line := range strings.Split(string(data), "\n") {
+
+		text := strings.TrimSpace(stripComment(line))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table %s", n+1, text)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if strings.Contains(section, ".") {
+				return nil, fmt.Errorf("line %d: nested tables are not supported", n+1)
+			}
+			continue
+		}
+
+		i := strings.Index(text, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n+1)
+		}
+		key, value := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+		if strings.Contains(key, ".") {
+			return nil, fmt.Errorf("line %d: dotted keys are not supported", n+1)
+		}
+		key = qualify(section, key)
+
+		if strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+			return nil, fmt.Errorf("line %d: multi-line strings are not supported", n+1)
+		}
+
+		if strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("line %d: multi-line arrays are not supported", n+1)
+		}
+
+		if strings.HasPrefix(value, "[") {
+			items, err := inlineList(value, tomlScalar)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			values[key] = items
+			continue
+		}
+
+		v, err := tomlScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+1, err)
+		}
+		values[key] = v
+	}
+
+	return values, nil
+}
+
+func tomlScalar(s string) (string, error) {
+
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s == "true" || s == "false":
+		return s, nil
+	}
+
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", s)
+	}
+
+	return strings.ReplaceAll(s, "_", ""), nil
+}
+
+// inlineList parses [a, b, c] into a comma separated value.
+func inlineList(s string, scalar func(string) (string, error)) (string, error) {
+
+	if !strings.HasSuffix(s, "]") {
+		return "", fmt.Errorf("unterminated list %s", s)
+	}
+
+	var items []string
+	for _, item := range splitList(s[1 : len(s)-1]) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		v, err := scalar(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, v)
+	}
+
+	return strings.Join(items, ","), nil
+}
+
+// splitList splits a list body on the commas outside quotes.
+func splitList(s string) []string {
+
+	var items []string
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(items, s[start:])
+}
+
+// stripComment removes a # comment that is not inside a quoted string.
+func stripComment(line string) string {
+
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,188 @@
+package ems
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadClientOptions_Files(t *testing.T) {
+
+	secret := writeConfig(t, "password", "s3cret\n")
+
+	files := map[string]string{
+		"ems.yaml": `
+# EMS connection
+url: "tcp://a:7222,tcp://b:7222"
+user: admin
+password_file: ` + secret + `
+reconnect-attempt-count: 10
+tls:
+  trusted_certs:
+    - /etc/ems/ca.pem
+    - /etc/ems/ca2.pem
+  verify_host_name: false  # test servers
+`,
+		"ems.toml": `
+url = "tcp://a:7222,tcp://b:7222"
+user = 'admin'
+password_file = "` + secret + `"
+reconnect_attempt_count = 10
+
+[ssl]
+trusted_certs = ["/etc/ems/ca.pem", "/etc/ems/ca2.pem"]
+verify_host_name = false # test servers
+`,
+		"ems.json": `{
+	"url": "tcp://a:7222,tcp://b:7222",
+	"username": "admin",
+	"password_file": "` + secret + `",
+	"reconnect_attempt_count": 10,
+	"ssl": {"trusted_certs": ["/etc/ems/ca.pem", "/etc/ems/ca2.pem"], "verify_host_name": false}
+}`,
+	}
+
+	for name, content := range files {
+		o, err := LoadClientOptions(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(o.GetServerUrls()) != 2 || o.GetUsername() != "admin" || o.GetPassword() != "s3cret" {
+			t.Errorf("%s: bad connection settings %v %q %q", name, o.GetServerUrls(), o.GetUsername(), o.GetPassword())
+		}
+
+		if o.GetReconnectAttemptCount() != 10 {
+			t.Errorf("%s: bad reconnect attempt count %d", name, o.GetReconnectAttemptCount())
+		}
+
+		if certs := o.GetSSLTrustedCertificates(); len(certs) != 2 || certs[1] != "/etc/ems/ca2.pem" || o.GetSSLVerifyHostName() {
+			t.Errorf("%s: bad SSL settings %v", name, certs)
+		}
+	}
+}
+
+func TestLoadClientOptions_Values(t *testing.T) {
+
+	files := map[string]string{
+		"ems.yaml": `
+reconnect_attempt_timeout: 1000000
+ssl:
+  ssl_ciphers: HIGH
+`,
+		"ems.toml": `
+reconnect_attempt_timeout = 1000000
+
+[tls]
+ssl_ciphers = "HIGH"
+`,
+		"ems.json": `{"reconnect_attempt_timeout": 1000000, "ssl": {"ssl_ciphers": "HIGH"}}`,
+	}
+
+	for name, content := range files {
+		o, err := LoadClientOptions(writeConfig(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if o.GetReconnectAttemptTimeout() != 1000000 {
+			t.Errorf("%s: bad reconnect attempt timeout %d", name, o.GetReconnectAttemptTimeout())
+		}
+
+		if o.GetSSLCiphers() != "HIGH" {
+			t.Errorf("%s: bad SSL ciphers %q", name, o.GetSSLCiphers())
+		}
+	}
+}
+
+func TestLoadClientOptions_RelativePaths(t *testing.T) {
+
+	path := writeConfig(t, "ems.yaml", "user: admin\npassword_file: password\nssl_trusted_certs: [certs/ca.pem, /etc/ems/ca.pem]\n")
+	dir := filepath.Dir(path)
+
+	if err := os.WriteFile(filepath.Join(dir, "password"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the working directory does not matter
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	o, err := LoadClientOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.GetPassword() != "s3cret" {
+		t.Errorf("bad password %q", o.GetPassword())
+	}
+
+	if certs := o.GetSSLTrustedCertificates(); len(certs) != 2 || certs[0] != filepath.Join(dir, "certs", "ca.pem") || certs[1] != "/etc/ems/ca.pem" {
+		t.Errorf("bad SSL trusted certificates %v", certs)
+	}
+}
+
+func TestLoadClientOptions_Env(t *testing.T) {
+
+	path := writeConfig(t, "ems.yaml", "url: tcp://file:7222\nuser: file\npassword: from-file\n")
+
+	t.Setenv("EMS_URL", "tcp://env:7222")
+	t.Setenv("EMS_PASSWORD_FILE", writeConfig(t, "password", "from-env"))
+	t.Setenv("EMS_TLS_CIPHERS", "HIGH")
+
+	o, err := LoadClientOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the environment overrides the file, and a secret file replaces the password
+	if o.GetServerUrl().Host != "env:7222" || o.GetUsername() != "file" || o.GetPassword() != "from-env" {
+		t.Fatalf("bad precedence %s %q %q", o.GetServerUrl().Host, o.GetUsername(), o.GetPassword())
+	}
+
+	if o.GetSSLCiphers() != "HIGH" {
+		t.Fatalf("bad ciphers %q", o.GetSSLCiphers())
+	}
+}
+
+func TestLoadClientOptions_Invalid(t *testing.T) {
+
+	for name, tc := range map[string]struct {
+		file    string
+		content string
+		msg     string
+	}{
+		"unknown key":   {"ems.yaml", "url: tcp://a:7222\nserver: x\n", "unknown setting"},
+		"both secrets":  {"ems.toml", "password = 'a'\npassword_file = 'b'\n", "both password and password_file"},
+		"bad integer":   {"ems.json", `{"reconnect_attempt_count": "ten"}`, "must be an integer"},
+		"bad yaml":      {"ems.yaml", "url tcp://a:7222\n", "expected key: value"},
+		"bad toml":      {"ems.toml", "url = tcp://a:7222\n", "invalid value"},
+		"bad extension": {"ems.ini", "url=tcp://a:7222\n", "unsupported configuration file type"},
+		"yaml block":    {"ems.yaml", "password: |\n  s3cret\n", "line 1: unsupported value |"},
+		"yaml nesting":  {"ems.yaml", "ssl:\n  identity:\n    cert: a.pem\n", "line 2: nested sections"},
+		"yaml empty":    {"ems.yaml", "ssl:\n  identity:\n  ciphers: HIGH\n", "line 2: nested sections"},
+		"toml string":   {"ems.toml", "password = \"\"\"\ns3cret\"\"\"\n", "line 1: multi-line strings"},
+		"toml array":    {"ems.toml", "[ssl]\ntrusted_certs = [\n  'a.pem',\n]\n", "line 2: multi-line arrays"},
+		"toml table":    {"ems.toml", "[ssl.identity]\ncert = 'a.pem'\n", "line 1: nested tables"},
+	} {
+		_, err := LoadClientOptions(writeConfig(t, tc.file, tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.msg, err)
+		}
+	}
+}
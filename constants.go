@@ -29,6 +29,18 @@ const (
 	TIBEMS_RELIABLE       = 22 /* Extension */
 ) //tibemsDeliveryMode
 
+const (
+	TIBEMS_SSL_ENCODING_AUTO     = 0x0000
+	TIBEMS_SSL_ENCODING_PEM      = 0x0001
+	TIBEMS_SSL_ENCODING_DER      = 0x0002
+	TIBEMS_SSL_ENCODING_BER      = 0x0004
+	TIBEMS_SSL_ENCODING_PKCS7    = 0x0010
+	TIBEMS_SSL_ENCODING_PKCS8    = 0x0020
+	TIBEMS_SSL_ENCODING_PKCS12   = 0x0040
+	TIBEMS_SSL_ENCODING_ENTRUST  = 0x0080
+	TIBEMS_SSL_ENCODING_KEYSTORE = 0x0100
+) //SSL certificate and key file encodings
+
 const (
 	NPSEND_CHECK_DEFAULT   = 0
 	NPSEND_CHECK_ALWAYS    = 1
@@ -49,6 +61,27 @@ const (
 	TIBEMS_MESSAGE_UNDEFINED = 256
 ) //tibemsMsgType
 
+const (
+	TIBEMS_NULL         = 0
+	TIBEMS_BOOL         = 1
+	TIBEMS_BYTE         = 2
+	TIBEMS_WCHAR        = 3
+	TIBEMS_SHORT        = 4
+	TIBEMS_INT          = 5
+	TIBEMS_LONG         = 6
+	TIBEMS_FLOAT        = 7
+	TIBEMS_DOUBLE       = 8
+	TIBEMS_UTF8         = 9
+	TIBEMS_BYTES        = 10
+	TIBEMS_MAP_MSG      = 11
+	TIBEMS_STREAM_MSG   = 12
+	TIBEMS_SHORT_ARRAY  = 20
+	TIBEMS_INT_ARRAY    = 21
+	TIBEMS_LONG_ARRAY   = 22
+	TIBEMS_FLOAT_ARRAY  = 23
+	TIBEMS_DOUBLE_ARRAY = 24
+) //tibemsMsgField type
+
 const (
 	TIBEMS_OK = 0
 
@@ -189,4 +222,5 @@ const (
 const (
 	disconnected uint32 = iota
 	connected
+	reconnecting
 )
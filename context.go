@@ -0,0 +1,44 @@
+package ems
+
+import (
+	"context"
+	"time"
+)
+
+// contextTimeout returns the time left before the deadline of ctx in
+// milliseconds, for use as an EMS timeout. It reports false if ctx has no deadline.
+func contextTimeout(ctx context.Context) (int, bool) {
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	// EMS treats a zero timeout as no timeout
+	remaining := int(time.Until(deadline) / time.Millisecond)
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	return remaining, true
+}
+
+// interruptOnDone calls interrupt if ctx is done before the returned stop
+// function is called. stop waits for an interrupt in progress to finish and
+// reports whether the interrupt ran.
+func interruptOnDone(ctx context.Context, interrupt func()) func() bool {
+
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(done)
+		interrupt()
+	})
+
+	return func() bool {
+		if stop() {
+			return false
+		}
+		<-done
+		return true
+	}
+}
@@ -0,0 +1,46 @@
+package ems
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestContextTimeout(t *testing.T) {
+
+	if _, ok := contextTimeout(context.Background()); ok {
+		t.Fatalf("background context has a timeout")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	timeout, ok := contextTimeout(ctx)
+	if !ok || timeout <= 1000 || timeout > 2000 {
+		t.Fatalf("bad timeout %d", timeout)
+	}
+
+	expired, cancel2 := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel2()
+
+	if timeout, _ := contextTimeout(expired); timeout != 1 {
+		t.Fatalf("expired context gave timeout %d", timeout)
+	}
+}
+
+func TestInterruptOnDone(t *testing.T) {
+
+	interrupted := false
+	stop := interruptOnDone(context.Background(), func() { interrupted = true })
+	if stop() || interrupted {
+		t.Fatalf("interrupted without cancel")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop = interruptOnDone(ctx, func() { interrupted = true })
+	cancel()
+
+	if !stop() || !interrupted {
+		t.Fatalf("cancel did not interrupt")
+	}
+}
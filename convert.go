@@ -0,0 +1,684 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// readMsg converts a received EMS message, rejecting body types that
+// are not supported.
+func (c *Client) readMsg(msg C.tibemsMsg) (Message, error) {
+
+	var msgType C.tibemsMsgType
+
+	// Check message type
+	status := C.tibemsMsg_GetBodyType(msg, &msgType)
+	if status != TIBEMS_OK {
+		return Message{}, c.newError(status)
+	}
+
+	switch msgType {
+	case TIBEMS_MESSAGE, TIBEMS_TEXT_MESSAGE, TIBEMS_BYTES_MESSAGE, TIBEMS_MAP_MESSAGE, TIBEMS_STREAM_MESSAGE:
+	default:
+		return Message{}, errors.New("Unable to process message type " + bodyTypeName(msgType))
+	}
+
+	return c.toMessage(msg, msgType)
+}
+
+func bodyTypeName(msgType C.tibemsMsgType) string {
+	switch msgType {
+	case TIBEMS_MESSAGE:
+		return BodyTypeMessage
+	case TIBEMS_TEXT_MESSAGE:
+		return BodyTypeText
+	case TIBEMS_BYTES_MESSAGE:
+		return BodyTypeBytes
+	case TIBEMS_OBJECT_MESSAGE:
+		return BodyTypeObject
+	case TIBEMS_STREAM_MESSAGE:
+		return BodyTypeStream
+	case TIBEMS_MAP_MESSAGE:
+		return BodyTypeMap
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// newMsg creates an EMS message of m's body type carrying the body, header
+// fields and properties of m. The returned release func destroys the message.
+func (c *Client) newMsg(m Message) (C.tibemsMsg, func(), error) {
+
+	var msg C.tibemsMsg
+	var replyTo C.tibemsDestination
+	var status C.tibems_status
+
+	switch m.BodyType {
+	case "", BodyTypeText:
+		status = C.tibemsTextMsg_Create(&msg)
+	case BodyTypeBytes:
+		status = C.tibemsBytesMsg_Create(&msg)
+	case BodyTypeMap:
+		status = C.tibemsMapMsg_Create(&msg)
+	case BodyTypeStream:
+		status = C.tibemsStreamMsg_Create(&msg)
+	case BodyTypeMessage:
+		status = C.tibemsMsg_Create(&msg)
+	default:
+		return nil, nil, errors.New("Unable to send message type " + m.BodyType)
+	}
+	if status != TIBEMS_OK {
+		return nil, nil, c.newError(status)
+	}
+
+	release := func() {
+		C.tibemsMsg_Destroy(msg)
+		if replyTo != nil {
+			C.tibemsDestination_Destroy(replyTo)
+		}
+	}
+
+	if err := c.setBody(msg, m); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	if m.CorrelationID != "" {
+		value := C.CString(m.CorrelationID)
+		status = C.tibemsMsg_SetCorrelationID(msg, value)
+		C.free(unsafe.Pointer(value))
+		if status != TIBEMS_OK {
+			err := c.newError(status)
+			release()
+			return nil, nil, err
+		}
+	}
+
+	if m.Type != "" {
+		value := C.CString(m.Type)
+		status = C.tibemsMsg_SetType(msg, value)
+		C.free(unsafe.Pointer(value))
+		if status != TIBEMS_OK {
+			err := c.newError(status)
+			release()
+			return nil, nil, err
+		}
+	}
+
+	if m.ReplyTo != "" {
+		name := C.CString(m.ReplyTo)
+		status = C.tibemsDestination_Create(&replyTo, toDestinationType(m.ReplyToType), name)
+		C.free(unsafe.Pointer(name))
+		if status != TIBEMS_OK {
+			err := c.newError(status)
+			release()
+			return nil, nil, err
+		}
+
+		status = C.tibemsMsg_SetReplyTo(msg, replyTo)
+		if status != TIBEMS_OK {
+			err := c.newError(status)
+			release()
+			return nil, nil, err
+		}
+	}
+
+	for name, value := range m.Properties {
+		if err := c.setProperty(msg, name, value); err != nil {
+			release()
+			return nil, nil, err
+		}
+	}
+
+	return msg, release, nil
+}
+
+// setBody writes the body of m to a message created for its body type.
+func (c *Client) setBody(msg C.tibemsMsg, m Message) error {
+
+	var status C.tibems_status
+
+	switch m.BodyType {
+	case "", BodyTypeText:
+		text := C.CString(m.Body)
+		defer C.free(unsafe.Pointer(text))
+
+		status = C.tibemsTextMsg_SetText(msg, text)
+	case BodyTypeBytes:
+		var bytes unsafe.Pointer
+		if len(m.Bytes) > 0 {
+			bytes = unsafe.Pointer(&m.Bytes[0])
+		}
+
+		status = C.tibemsBytesMsg_SetBytes(msg, bytes, C.tibems_uint(len(m.Bytes)))
+	case BodyTypeMap:
+		return c.setMap(msg, m.Map)
+	case BodyTypeStream:
+		return c.setStream(msg, m.Stream)
+	}
+
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+// setMap writes the fields of a Go map to a map message, storing nested
+// maps as map message fields.
+func (c *Client) setMap(msg C.tibemsMapMsg, fields map[string]interface{}) error {
+
+	for name, value := range fields {
+		if err := c.setMapField(msg, name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) setMapField(msg C.tibemsMapMsg, name string, value interface{}) error {
+
+	var status C.tibems_status
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	switch v := value.(type) {
+	case bool:
+		var b C.tibems_bool = TIBEMS_FALSE
+		if v {
+			b = TIBEMS_TRUE
+		}
+		status = C.tibemsMapMsg_SetBoolean(msg, cname, b)
+	case int8:
+		status = C.tibemsMapMsg_SetByte(msg, cname, C.tibems_byte(v))
+	case int16:
+		status = C.tibemsMapMsg_SetShort(msg, cname, C.tibems_short(v))
+	case int32:
+		status = C.tibemsMapMsg_SetInt(msg, cname, C.tibems_int(v))
+	case int64:
+		status = C.tibemsMapMsg_SetLong(msg, cname, C.tibems_long(v))
+	case int:
+		status = C.tibemsMapMsg_SetLong(msg, cname, C.tibems_long(v))
+	case float32:
+		status = C.tibemsMapMsg_SetFloat(msg, cname, C.tibems_float(v))
+	case float64:
+		status = C.tibemsMapMsg_SetDouble(msg, cname, C.tibems_double(v))
+	case string:
+		cvalue := C.CString(v)
+		defer C.free(unsafe.Pointer(cvalue))
+		status = C.tibemsMapMsg_SetString(msg, cname, cvalue)
+	case []byte:
+		var bytes unsafe.Pointer
+		if len(v) > 0 {
+			bytes = unsafe.Pointer(&v[0])
+		}
+		status = C.tibemsMapMsg_SetBytes(msg, cname, bytes, C.tibems_uint(len(v)))
+	case map[string]interface{}:
+		var nested C.tibemsMapMsg
+
+		status = C.tibemsMapMsg_Create(&nested)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+
+		if err := c.setMap(nested, v); err != nil {
+			C.tibemsMsg_Destroy(nested)
+			return err
+		}
+
+		// the outer message takes ownership of the nested one
+		status = C.tibemsMapMsg_SetMapMsg(msg, cname, nested, TIBEMS_TRUE)
+		if status != TIBEMS_OK {
+			err := c.newError(status)
+			C.tibemsMsg_Destroy(nested)
+			return err
+		}
+	case []interface{}:
+		var nested C.tibemsStreamMsg
+
+		status = C.tibemsStreamMsg_Create(&nested)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+
+		if err := c.setStream(nested, v); err != nil {
+			C.tibemsMsg_Destroy(nested)
+			return err
+		}
+
+		status = C.tibemsMapMsg_SetStreamMsg(msg, cname, nested, TIBEMS_TRUE)
+		if status != TIBEMS_OK {
+			err := c.newError(status)
+			C.tibemsMsg_Destroy(nested)
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported type %T for map field %s", value, name)
+	}
+
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+// setStream writes values to a stream message in order, storing nested maps
+// and slices as map and stream message fields.
+func (c *Client) setStream(msg C.tibemsStreamMsg, values []interface{}) error {
+
+	for i, value := range values {
+		if err := c.writeStreamField(msg, i, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) writeStreamField(msg C.tibemsStreamMsg, index int, value interface{}) error {
+
+	var status C.tibems_status
+
+	switch v := value.(type) {
+	case bool:
+		var b C.tibems_bool = TIBEMS_FALSE
+		if v {
+			b = TIBEMS_TRUE
+		}
+		status = C.tibemsStreamMsg_WriteBoolean(msg, b)
+	case int8:
+		status = C.tibemsStreamMsg_WriteByte(msg, C.tibems_byte(v))
+	case int16:
+		status = C.tibemsStreamMsg_WriteShort(msg, C.tibems_short(v))
+	case int32:
+		status = C.tibemsStreamMsg_WriteInt(msg, C.tibems_int(v))
+	case int64:
+		status = C.tibemsStreamMsg_WriteLong(msg, C.tibems_long(v))
+	case int:
+		status = C.tibemsStreamMsg_WriteLong(msg, C.tibems_long(v))
+	case float32:
+		status = C.tibemsStreamMsg_WriteFloat(msg, C.tibems_float(v))
+	case float64:
+		status = C.tibemsStreamMsg_WriteDouble(msg, C.tibems_double(v))
+	case string:
+		cvalue := C.CString(v)
+		defer C.free(unsafe.Pointer(cvalue))
+		status = C.tibemsStreamMsg_WriteString(msg, cvalue)
+	case []byte:
+		var bytes unsafe.Pointer
+		if len(v) > 0 {
+			bytes = unsafe.Pointer(&v[0])
+		}
+		status = C.tibemsStreamMsg_WriteBytes(msg, bytes, C.tibems_uint(len(v)))
+	case map[string]interface{}:
+		var nested C.tibemsMapMsg
+
+		status = C.tibemsMapMsg_Create(&nested)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+		// the stream copies the nested message
+		defer C.tibemsMsg_Destroy(nested)
+
+		if err := c.setMap(nested, v); err != nil {
+			return err
+		}
+
+		status = C.tibemsStreamMsg_WriteMapMsg(msg, nested)
+	case []interface{}:
+		var nested C.tibemsStreamMsg
+
+		status = C.tibemsStreamMsg_Create(&nested)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+		defer C.tibemsMsg_Destroy(nested)
+
+		if err := c.setStream(nested, v); err != nil {
+			return err
+		}
+
+		status = C.tibemsStreamMsg_WriteStreamMsg(msg, nested)
+	default:
+		return fmt.Errorf("unsupported type %T for stream field %d", value, index)
+	}
+
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+func (c *Client) setProperty(msg C.tibemsMsg, name string, value interface{}) error {
+
+	var status C.tibems_status
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	switch v := value.(type) {
+	case bool:
+		var b C.tibems_bool = TIBEMS_FALSE
+		if v {
+			b = TIBEMS_TRUE
+		}
+		status = C.tibemsMsg_SetBooleanProperty(msg, cname, b)
+	case int8:
+		status = C.tibemsMsg_SetByteProperty(msg, cname, C.tibems_byte(v))
+	case int16:
+		status = C.tibemsMsg_SetShortProperty(msg, cname, C.tibems_short(v))
+	case int32:
+		status = C.tibemsMsg_SetIntProperty(msg, cname, C.tibems_int(v))
+	case int64:
+		status = C.tibemsMsg_SetLongProperty(msg, cname, C.tibems_long(v))
+	case float32:
+		status = C.tibemsMsg_SetFloatProperty(msg, cname, C.tibems_float(v))
+	case float64:
+		status = C.tibemsMsg_SetDoubleProperty(msg, cname, C.tibems_double(v))
+	case string:
+		cvalue := C.CString(v)
+		defer C.free(unsafe.Pointer(cvalue))
+		status = C.tibemsMsg_SetStringProperty(msg, cname, cvalue)
+	default:
+		return fmt.Errorf("unsupported type %T for property %s", value, name)
+	}
+
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+// toMessage reads the body, header fields and properties of an EMS message.
+func (c *Client) toMessage(msg C.tibemsMsg, msgType C.tibemsMsgType) (Message, error) {
+
+	var value *C.char
+	var priority C.tibems_int
+	var timestamp C.tibems_long
+	var redelivered C.tibems_bool
+	var replyTo C.tibemsDestination
+
+	m := Message{
+		BodyType:   bodyTypeName(msgType),
+		Properties: make(map[string]interface{}),
+	}
+
+	if err := c.getBody(msg, &m); err != nil {
+		return Message{}, err
+	}
+
+	if C.tibemsMsg_GetMessageID(msg, &value) == TIBEMS_OK {
+		m.MessageID = C.GoString(value)
+	}
+
+	if C.tibemsMsg_GetCorrelationID(msg, &value) == TIBEMS_OK {
+		m.CorrelationID = C.GoString(value)
+	}
+
+	if C.tibemsMsg_GetType(msg, &value) == TIBEMS_OK {
+		m.Type = C.GoString(value)
+	}
+
+	if C.tibemsMsg_GetPriority(msg, &priority) == TIBEMS_OK {
+		m.Priority = int(priority)
+	}
+
+	if C.tibemsMsg_GetTimestamp(msg, &timestamp) == TIBEMS_OK && timestamp != 0 {
+		m.Timestamp = time.Unix(0, int64(timestamp)*int64(time.Millisecond))
+	}
+
+	if C.tibemsMsg_GetRedelivered(msg, &redelivered) == TIBEMS_OK {
+		m.Redelivered = redelivered == TIBEMS_TRUE
+	}
+
+	if C.tibemsMsg_GetReplyTo(msg, &replyTo) == TIBEMS_OK && replyTo != nil {
+		m.ReplyTo, m.ReplyToType = destinationName(replyTo)
+	}
+
+	if err := c.getProperties(msg, m.Properties); err != nil {
+		return Message{}, err
+	}
+
+	return m, nil
+}
+
+// getBody reads the body of msg into m according to m.BodyType.
+func (c *Client) getBody(msg C.tibemsMsg, m *Message) error {
+
+	var status C.tibems_status
+
+	switch m.BodyType {
+	case BodyTypeText:
+		var text *C.char
+
+		// Get the string data from the text message
+		status = C.tibemsTextMsg_GetText(msg, &text)
+		m.Body = C.GoString(text)
+	case BodyTypeBytes:
+		var bytes unsafe.Pointer
+		var size C.tibems_uint
+
+		status = C.tibemsBytesMsg_GetBytes(msg, &bytes, &size)
+		if status == TIBEMS_OK {
+			m.Bytes = C.GoBytes(bytes, C.int(size))
+		}
+	case BodyTypeMap:
+		fields, err := c.getMap(msg)
+		if err != nil {
+			return err
+		}
+		m.Map = fields
+	case BodyTypeStream:
+		values, err := c.getStream(msg)
+		if err != nil {
+			return err
+		}
+		m.Stream = values
+	}
+
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+func (c *Client) getProperties(msg C.tibemsMsg, props map[string]interface{}) error {
+
+	var names C.tibemsMsgEnum
+
+	status := C.tibemsMsg_GetPropertyNames(msg, &names)
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+	defer C.tibemsMsgEnum_Destroy(names)
+
+	for {
+		var name *C.char
+		var field C.tibemsMsgField
+
+		status = C.tibemsMsgEnum_GetNextName(names, &name)
+		if status == TIBEMS_NOT_FOUND {
+			return nil
+		}
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+
+		status = C.tibemsMsg_GetProperty(msg, name, &field)
+		if status != TIBEMS_OK {
+			return c.newError(status)
+		}
+
+		value, err := c.fieldValue(&field)
+		if err != nil {
+			return err
+		}
+		props[C.GoString(name)] = value
+	}
+}
+
+// getMap reads the fields of a map message into a Go map.
+func (c *Client) getMap(msg C.tibemsMapMsg) (map[string]interface{}, error) {
+
+	var names C.tibemsMsgEnum
+
+	status := C.tibemsMapMsg_GetMapNames(msg, &names)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+	defer C.tibemsMsgEnum_Destroy(names)
+
+	fields := make(map[string]interface{})
+
+	for {
+		var name *C.char
+		var field C.tibemsMsgField
+
+		status = C.tibemsMsgEnum_GetNextName(names, &name)
+		if status == TIBEMS_NOT_FOUND {
+			return fields, nil
+		}
+		if status != TIBEMS_OK {
+			return nil, c.newError(status)
+		}
+
+		status = C.tibemsMapMsg_GetField(msg, name, &field)
+		if status != TIBEMS_OK {
+			return nil, c.newError(status)
+		}
+
+		value, err := c.fieldValue(&field)
+		if err != nil {
+			return nil, err
+		}
+		fields[C.GoString(name)] = value
+	}
+}
+
+// getStream reads the fields of a stream message in order until TIBEMS_MSG_EOF.
+func (c *Client) getStream(msg C.tibemsStreamMsg) ([]interface{}, error) {
+
+	values := []interface{}{}
+
+	// read from the first field
+	status := C.tibemsStreamMsg_Reset(msg)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+
+	for {
+		var field C.tibemsMsgField
+
+		status = C.tibemsStreamMsg_ReadField(msg, &field)
+		if status == TIBEMS_MSG_EOF {
+			return values, nil
+		}
+		if status != TIBEMS_OK {
+			return nil, c.newError(status)
+		}
+
+		value, err := c.fieldValue(&field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// fieldValue converts a message field to the matching Go type. Nested map
+// and stream messages become nested Go maps and []interface{} values, and
+// array fields become Go slices.
+func (c *Client) fieldValue(field *C.tibemsMsgField) (interface{}, error) {
+
+	data := unsafe.Pointer(&field.data)
+	array := *(*unsafe.Pointer)(data)
+
+	switch field._type {
+	case TIBEMS_BOOL:
+		return *(*C.tibems_bool)(data) == TIBEMS_TRUE, nil
+	case TIBEMS_BYTE:
+		return int8(*(*C.tibems_byte)(data)), nil
+	case TIBEMS_WCHAR:
+		return rune(*(*C.tibems_wchar)(data)), nil
+	case TIBEMS_SHORT:
+		return int16(*(*C.tibems_short)(data)), nil
+	case TIBEMS_INT:
+		return int32(*(*C.tibems_int)(data)), nil
+	case TIBEMS_LONG:
+		return int64(*(*C.tibems_long)(data)), nil
+	case TIBEMS_FLOAT:
+		return float32(*(*C.tibems_float)(data)), nil
+	case TIBEMS_DOUBLE:
+		return float64(*(*C.tibems_double)(data)), nil
+	case TIBEMS_UTF8:
+		return C.GoString(*(**C.char)(data)), nil
+	case TIBEMS_BYTES:
+		return C.GoBytes(array, C.int(field.size)), nil
+	case TIBEMS_MAP_MSG:
+		return c.getMap(*(*C.tibemsMsg)(data))
+	case TIBEMS_STREAM_MSG:
+		return c.getStream(*(*C.tibemsMsg)(data))
+	case TIBEMS_SHORT_ARRAY:
+		return copyArray[int16, C.tibems_short](array, field.count), nil
+	case TIBEMS_INT_ARRAY:
+		return copyArray[int32, C.tibems_int](array, field.count), nil
+	case TIBEMS_LONG_ARRAY:
+		return copyArray[int64, C.tibems_long](array, field.count), nil
+	case TIBEMS_FLOAT_ARRAY:
+		return copyArray[float32, C.tibems_float](array, field.count), nil
+	case TIBEMS_DOUBLE_ARRAY:
+		return copyArray[float64, C.tibems_double](array, field.count), nil
+	}
+
+	return nil, nil
+}
+
+// copyArray copies a C array of count elements into a Go slice.
+func copyArray[T int16 | int32 | int64 | float32 | float64, E C.tibems_short | C.tibems_int | C.tibems_long | C.tibems_float | C.tibems_double](array unsafe.Pointer, count C.tibems_int) []T {
+
+	values := make([]T, int(count))
+	if array == nil {
+		return values
+	}
+
+	for i, v := range unsafe.Slice((*E)(array), int(count)) {
+		values[i] = T(v)
+	}
+
+	return values
+}
+
+// destinationName returns the name of the destination and its type as
+// accepted by the destinationType arguments ("queue" or "topic").
+func destinationName(dest C.tibemsDestination) (string, string) {
+
+	var destType C.tibemsDestinationType
+
+	buf := (*C.char)(C.calloc(1024, 1))
+	defer C.free(unsafe.Pointer(buf))
+
+	if C.tibemsDestination_GetName(dest, buf, 1024) != TIBEMS_OK {
+		return "", ""
+	}
+
+	typeName := "queue"
+	if C.tibemsDestination_GetType(dest, &destType) == TIBEMS_OK && destType == TIBEMS_TOPIC {
+		typeName = "topic"
+	}
+
+	return C.GoString(buf), typeName
+}
@@ -0,0 +1,81 @@
+package ems
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialsProvider supplies the username and password for each Connect.
+// EMS reuses the credentials of a connection for its automatic fault-tolerant
+// reconnects; a connection the server has dropped picks up new credentials on
+// the next Connect.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (username string, password string, err error)
+}
+
+// staticCredentials returns the same credentials every time.
+type staticCredentials struct {
+	username string
+	password string
+}
+
+// NewStaticCredentialsProvider returns a provider of fixed credentials.
+func NewStaticCredentialsProvider(username string, password string) CredentialsProvider {
+	return &staticCredentials{username: username, password: password}
+}
+
+func (s *staticCredentials) Credentials(ctx context.Context) (string, string, error) {
+	return s.username, s.password, nil
+}
+
+// FileCredentialsProvider reads the password from a file, such as one written
+// by a secrets sidecar, and reads it again on a Connect after the file's
+// modification time or size changes. Trailing line breaks are removed, and an
+// empty file is an empty password. The file is not watched: a connection
+// keeps the password it connected with, including for automatic reconnects.
+// Writers should replace the file atomically, e.g. by renaming a new file
+// over it.
+type FileCredentialsProvider struct {
+	username string
+	path     string
+	password string
+	loaded   bool
+	modTime  time.Time
+	size     int64
+	sync.Mutex
+}
+
+// NewFileCredentialsProvider returns a provider of the username and the
+// password held in the file at path.
+func NewFileCredentialsProvider(username string, path string) *FileCredentialsProvider {
+	return &FileCredentialsProvider{username: username, path: path}
+}
+
+func (f *FileCredentialsProvider) Credentials(ctx context.Context) (string, string, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read password file: %v", err)
+	}
+
+	if f.loaded && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.username, f.password, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read password file: %v", err)
+	}
+
+	f.password = strings.TrimRight(string(data), "\r\n")
+	f.loaded, f.modTime, f.size = true, info.ModTime(), info.Size()
+
+	return f.username, f.password, nil
+}
@@ -0,0 +1,84 @@
+package ems
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCredentialsProvider(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "password")
+	write := func(password string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(password), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewFileCredentialsProvider("admin", path)
+
+	if _, _, err := p.Credentials(context.Background()); err == nil {
+		t.Fatalf("expected error for a missing file")
+	}
+
+	now := time.Now()
+	write("first\n", now)
+
+	user, pass, err := p.Credentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "admin" || pass != "first" {
+		t.Fatalf("bad credentials %q %q", user, pass)
+	}
+
+	// a rotated password is picked up
+	write("second\n", now.Add(time.Second))
+
+	if _, pass, _ = p.Credentials(context.Background()); pass != "second" {
+		t.Fatalf("rotated password not read, got %q", pass)
+	}
+
+	// EMS users may have an empty password
+	write("", now.Add(2*time.Second))
+
+	if _, pass, err = p.Credentials(context.Background()); err != nil || pass != "" {
+		t.Fatalf("expected an empty password, got %q %v", pass, err)
+	}
+}
+
+type failingCredentials struct{}
+
+func (failingCredentials) Credentials(ctx context.Context) (string, string, error) {
+	return "", "", errors.New("vault unavailable")
+}
+
+func TestCredentialsProvider_Connect(t *testing.T) {
+
+	o, err := applyClientOptions([]ClientOption{WithServerURL("tcp://memory-credentials:7222"), WithCredentialsProvider(failingCredentials{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewMemoryClient(o)
+
+	if err := c.Connect(); err == nil || !strings.Contains(err.Error(), "vault unavailable") {
+		t.Fatalf("expected connect to fail with the provider's error, got %v", err)
+	}
+
+	_, err = NewClientWithOptions(
+		WithServerURL("tcp://memory-credentials:7222"),
+		WithCredentials("admin", "secret"),
+		WithCredentialsProvider(NewStaticCredentialsProvider("admin", "secret")),
+	)
+	if err == nil {
+		t.Fatalf("expected conflicting credentials error")
+	}
+}
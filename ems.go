@@ -0,0 +1,78 @@
+// Package ems is a Go client for TIBCO EMS.
+//
+// The client built on the TIBCO EMS C library is compiled only with the
+// tibems build tag (go build -tags tibems). Without the tag the package
+// still builds, and can be tested with the in-memory client from
+// NewMemoryClient, but Connect on a client from NewClient fails.
+package ems
+
+import "context"
+
+type IClient interface {
+	IsConnected() bool
+	Metadata() (ConnectionMetadata, error)
+	Events() <-chan ConnectionEvent
+	Connect() error
+	ConnectContext(ctx context.Context) error
+	Disconnect() error
+	Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error
+	SendContext(ctx context.Context, destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error
+	SendReceive(destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error)
+	SendReceiveContext(ctx context.Context, destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error)
+	Request(destination string, destinationType string, request Message, timeout int, deliveryMode string, expiration int) (Message, bool, error)
+	RequestContext(ctx context.Context, destination string, destinationType string, request Message, timeout int, deliveryMode string, expiration int) (Message, bool, error)
+	Receive(destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error)
+	ReceiveContext(ctx context.Context, destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error)
+	SendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error
+	SendMessageContext(ctx context.Context, destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error
+	ReceiveMessage(destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error)
+	ReceiveMessageContext(ctx context.Context, destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error)
+	Subscribe(destination string, destinationType string, handler MessageHandler, opts ...ConsumerOption) (ISubscription, error)
+	Unsubscribe(name string) error
+	LookupDestination(name string) (string, string, error)
+	Browse(queue string, opts ...ConsumerOption) (IQueueBrowser, error)
+	Serve(destination string, handler RequestHandler, concurrency int, opts ...ConsumerOption) (ISubscription, error)
+	Begin() (ITx, error)
+}
+
+// ConnectionMetadata describes the connection of a connected client.
+type ConnectionMetadata struct {
+	ClientID        string   // client ID of the connection, empty if none is set
+	ProviderName    string   // EMS provider name
+	EMSVersion      string   // version of the EMS client library
+	ProviderVersion string   // version of the EMS provider
+	ActiveURL       string   // URL of the server in use; in a fault-tolerant pair, the active server
+	ServerURLs      []string // configured server URLs
+	FaultTolerant   bool     // whether more than one server URL is configured
+}
+
+// MessageHandler is called for every message delivered to a subscription.
+type MessageHandler func(msg Message)
+
+// ISubscription is a long-lived consumer started by Subscribe.
+type ISubscription interface {
+	Close() error
+}
+
+// IQueueBrowser iterates over the messages on a queue without consuming them.
+// Next reports false once there are no more messages.
+type IQueueBrowser interface {
+	Next() (Message, bool, error)
+	Close() error
+}
+
+// ITx is a transacted session started by Begin. Messages sent through it are
+// delivered, and messages received through it are consumed, only when Commit
+// succeeds; Rollback discards the sends and redelivers the received messages.
+// A new transaction starts after each Commit or Rollback until Close, which
+// rolls back any uncommitted work. Receive and ReceiveMessage need a timeout
+// greater than 0, as Commit, Rollback and Close wait for them to return.
+type ITx interface {
+	Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error
+	SendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error
+	Receive(destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error)
+	ReceiveMessage(destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error)
+	Commit() error
+	Rollback() error
+	Close() error
+}
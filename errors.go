@@ -0,0 +1,210 @@
+package ems
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is returned when an EMS call fails. It carries the tibems_status
+// code along with the error string and stack trace from the error context.
+//
+// Errors compare equal under errors.Is when their status codes match, so
+// callers can test for conditions using the sentinel values below:
+//
+//	if errors.Is(err, ems.ErrTimeout) { ... }
+type Error struct {
+	Status     int
+	Name       string
+	Message    string
+	StackTrace string
+}
+
+var (
+	ErrIllegalState             = statusError(TIBEMS_ILLEGAL_STATE)
+	ErrInvalidClientID          = statusError(TIBEMS_INVALID_CLIENT_ID)
+	ErrInvalidDestination       = statusError(TIBEMS_INVALID_DESTINATION)
+	ErrInvalidSelector          = statusError(TIBEMS_INVALID_SELECTOR)
+	ErrException                = statusError(TIBEMS_EXCEPTION)
+	ErrSecurityException        = statusError(TIBEMS_SECURITY_EXCEPTION)
+	ErrMsgEOF                   = statusError(TIBEMS_MSG_EOF)
+	ErrServerNotConnected       = statusError(TIBEMS_SERVER_NOT_CONNECTED)
+	ErrServerLimit              = statusError(TIBEMS_SERVER_LIMIT)
+	ErrServerDisconnected       = statusError(TIBEMS_SERVER_DISCONNECTED)
+	ErrServerReconnecting       = statusError(TIBEMS_SERVER_RECONNECTING)
+	ErrServerReconnected        = statusError(TIBEMS_SERVER_RECONNECTED)
+	ErrNotPermitted             = statusError(TIBEMS_NOT_PERMITTED)
+	ErrNotFound                 = statusError(TIBEMS_NOT_FOUND)
+	ErrTimeout                  = statusError(TIBEMS_TIMEOUT)
+	ErrIntr                     = statusError(TIBEMS_INTR)
+	ErrDestinationLimitExceeded = statusError(TIBEMS_DESTINATION_LIMIT_EXCEEDED)
+	ErrMemLimitExceeded         = statusError(TIBEMS_MEM_LIMIT_EXCEEDED)
+	ErrInvalidConnection        = statusError(TIBEMS_INVALID_CONNECTION)
+	ErrInvalidSession           = statusError(TIBEMS_INVALID_SESSION)
+	ErrTransactionFailed        = statusError(TIBEMS_TRANSACTION_FAILED)
+	ErrTransactionRollback      = statusError(TIBEMS_TRANSACTION_ROLLBACK)
+	ErrTransactionRetry         = statusError(TIBEMS_TRANSACTION_RETRY)
+	ErrFTServerLacksTransaction = statusError(TIBEMS_FT_SERVER_LACKS_TRANSACTION)
+	ErrInvalidCert              = statusError(TIBEMS_INVALID_CERT)
+	ErrInvalidCertNotYet        = statusError(TIBEMS_INVALID_CERT_NOT_YET)
+	ErrInvalidCertExpired       = statusError(TIBEMS_INVALID_CERT_EXPIRED)
+	ErrInvalidCertData          = statusError(TIBEMS_INVALID_CERT_DATA)
+	ErrAlgorithm                = statusError(TIBEMS_ALGORITHM_ERROR)
+	ErrSSL                      = statusError(TIBEMS_SSL_ERROR)
+	ErrInvalidPrivateKey        = statusError(TIBEMS_INVALID_PRIVATE_KEY)
+	ErrInvalidEncoding          = statusError(TIBEMS_INVALID_ENCODING)
+	ErrNotEnoughRandom          = statusError(TIBEMS_NOT_ENOUGH_RANDOM)
+	ErrInvalidCRLData           = statusError(TIBEMS_INVALID_CRL_DATA)
+	ErrCRLOff                   = statusError(TIBEMS_CRL_OFF)
+	ErrEmptyCRL                 = statusError(TIBEMS_EMPTY_CRL)
+)
+
+// IsSSLError reports whether err is an *Error with one of the SSL status
+// codes, TIBEMS_INVALID_CERT to TIBEMS_EMPTY_CRL.
+func IsSSLError(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status >= TIBEMS_INVALID_CERT && e.Status <= TIBEMS_EMPTY_CRL
+}
+
+// errTxClosed is returned by calls on a closed transaction.
+var errTxClosed = newError(TIBEMS_ILLEGAL_STATE, "transaction is closed", "")
+
+// errTxTimeout is returned by a transaction receive without a timeout, which
+// would block Commit, Rollback and Close until a message arrives.
+var errTxTimeout = newError(TIBEMS_INVALID_ARG, "transaction receive needs a timeout greater than 0", "")
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// Is reports whether target is an *Error with the same status code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Status == e.Status
+}
+
+// StatusName returns the symbolic name of a tibems_status code,
+// e.g. "TIBEMS_SERVER_NOT_CONNECTED".
+func StatusName(status int) string {
+	if name, ok := statusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("TIBEMS_STATUS_%d", status)
+}
+
+func newError(status int, message string, stackTrace string) *Error {
+	return &Error{
+		Status:     status,
+		Name:       StatusName(status),
+		Message:    message,
+		StackTrace: stackTrace,
+	}
+}
+
+func statusError(status int) *Error {
+	return newError(status, "", "")
+}
+
+var statusNames = map[int]string{
+	TIBEMS_OK:                          "TIBEMS_OK",
+	TIBEMS_ILLEGAL_STATE:               "TIBEMS_ILLEGAL_STATE",
+	TIBEMS_INVALID_CLIENT_ID:           "TIBEMS_INVALID_CLIENT_ID",
+	TIBEMS_INVALID_DESTINATION:         "TIBEMS_INVALID_DESTINATION",
+	TIBEMS_INVALID_SELECTOR:            "TIBEMS_INVALID_SELECTOR",
+	TIBEMS_EXCEPTION:                   "TIBEMS_EXCEPTION",
+	TIBEMS_SECURITY_EXCEPTION:          "TIBEMS_SECURITY_EXCEPTION",
+	TIBEMS_MSG_EOF:                     "TIBEMS_MSG_EOF",
+	TIBEMS_MSG_NOT_READABLE:            "TIBEMS_MSG_NOT_READABLE",
+	TIBEMS_MSG_NOT_WRITEABLE:           "TIBEMS_MSG_NOT_WRITEABLE",
+	TIBEMS_SERVER_NOT_CONNECTED:        "TIBEMS_SERVER_NOT_CONNECTED",
+	TIBEMS_VERSION_MISMATCH:            "TIBEMS_VERSION_MISMATCH",
+	TIBEMS_SUBJECT_COLLISION:           "TIBEMS_SUBJECT_COLLISION",
+	TIBEMS_INVALID_PROTOCOL:            "TIBEMS_INVALID_PROTOCOL",
+	TIBEMS_INVALID_HOSTNAME:            "TIBEMS_INVALID_HOSTNAME",
+	TIBEMS_INVALID_PORT:                "TIBEMS_INVALID_PORT",
+	TIBEMS_NO_MEMORY:                   "TIBEMS_NO_MEMORY",
+	TIBEMS_INVALID_ARG:                 "TIBEMS_INVALID_ARG",
+	TIBEMS_SERVER_LIMIT:                "TIBEMS_SERVER_LIMIT",
+	TIBEMS_MSG_DUPLICATE:               "TIBEMS_MSG_DUPLICATE",
+	TIBEMS_SERVER_DISCONNECTED:         "TIBEMS_SERVER_DISCONNECTED",
+	TIBEMS_SERVER_RECONNECTING:         "TIBEMS_SERVER_RECONNECTING",
+	TIBEMS_NOT_PERMITTED:               "TIBEMS_NOT_PERMITTED",
+	TIBEMS_SERVER_RECONNECTED:          "TIBEMS_SERVER_RECONNECTED",
+	TIBEMS_INVALID_NAME:                "TIBEMS_INVALID_NAME",
+	TIBEMS_INVALID_TYPE:                "TIBEMS_INVALID_TYPE",
+	TIBEMS_INVALID_SIZE:                "TIBEMS_INVALID_SIZE",
+	TIBEMS_INVALID_COUNT:               "TIBEMS_INVALID_COUNT",
+	TIBEMS_NOT_FOUND:                   "TIBEMS_NOT_FOUND",
+	TIBEMS_ID_IN_USE:                   "TIBEMS_ID_IN_USE",
+	TIBEMS_ID_CONFLICT:                 "TIBEMS_ID_CONFLICT",
+	TIBEMS_CONVERSION_FAILED:           "TIBEMS_CONVERSION_FAILED",
+	TIBEMS_INVALID_MSG:                 "TIBEMS_INVALID_MSG",
+	TIBEMS_INVALID_FIELD:               "TIBEMS_INVALID_FIELD",
+	TIBEMS_INVALID_INSTANCE:            "TIBEMS_INVALID_INSTANCE",
+	TIBEMS_CORRUPT_MSG:                 "TIBEMS_CORRUPT_MSG",
+	TIBEMS_PRODUCER_FAILED:             "TIBEMS_PRODUCER_FAILED",
+	TIBEMS_TIMEOUT:                     "TIBEMS_TIMEOUT",
+	TIBEMS_INTR:                        "TIBEMS_INTR",
+	TIBEMS_DESTINATION_LIMIT_EXCEEDED:  "TIBEMS_DESTINATION_LIMIT_EXCEEDED",
+	TIBEMS_MEM_LIMIT_EXCEEDED:          "TIBEMS_MEM_LIMIT_EXCEEDED",
+	TIBEMS_USER_INTR:                   "TIBEMS_USER_INTR",
+	TIBEMS_INVALID_QUEUE_GROUP:         "TIBEMS_INVALID_QUEUE_GROUP",
+	TIBEMS_INVALID_TIME_INTERVAL:       "TIBEMS_INVALID_TIME_INTERVAL",
+	TIBEMS_INVALID_IO_SOURCE:           "TIBEMS_INVALID_IO_SOURCE",
+	TIBEMS_INVALID_IO_CONDITION:        "TIBEMS_INVALID_IO_CONDITION",
+	TIBEMS_SOCKET_LIMIT:                "TIBEMS_SOCKET_LIMIT",
+	TIBEMS_OS_ERROR:                    "TIBEMS_OS_ERROR",
+	TIBEMS_WOULD_BLOCK:                 "TIBEMS_WOULD_BLOCK",
+	TIBEMS_INSUFFICIENT_BUFFER:         "TIBEMS_INSUFFICIENT_BUFFER",
+	TIBEMS_EOF:                         "TIBEMS_EOF",
+	TIBEMS_INVALID_FILE:                "TIBEMS_INVALID_FILE",
+	TIBEMS_FILE_NOT_FOUND:              "TIBEMS_FILE_NOT_FOUND",
+	TIBEMS_IO_FAILED:                   "TIBEMS_IO_FAILED",
+	TIBEMS_NOT_FILE_OWNER:              "TIBEMS_NOT_FILE_OWNER",
+	TIBEMS_ALREADY_EXISTS:              "TIBEMS_ALREADY_EXISTS",
+	TIBEMS_INVALID_CONNECTION:          "TIBEMS_INVALID_CONNECTION",
+	TIBEMS_INVALID_SESSION:             "TIBEMS_INVALID_SESSION",
+	TIBEMS_INVALID_CONSUMER:            "TIBEMS_INVALID_CONSUMER",
+	TIBEMS_INVALID_PRODUCER:            "TIBEMS_INVALID_PRODUCER",
+	TIBEMS_INVALID_USER:                "TIBEMS_INVALID_USER",
+	TIBEMS_INVALID_GROUP:               "TIBEMS_INVALID_GROUP",
+	TIBEMS_TRANSACTION_FAILED:          "TIBEMS_TRANSACTION_FAILED",
+	TIBEMS_TRANSACTION_ROLLBACK:        "TIBEMS_TRANSACTION_ROLLBACK",
+	TIBEMS_TRANSACTION_RETRY:           "TIBEMS_TRANSACTION_RETRY",
+	TIBEMS_INVALID_XARESOURCE:          "TIBEMS_INVALID_XARESOURCE",
+	TIBEMS_FT_SERVER_LACKS_TRANSACTION: "TIBEMS_FT_SERVER_LACKS_TRANSACTION",
+	TIBEMS_LDAP_ERROR:                  "TIBEMS_LDAP_ERROR",
+	TIBEMS_INVALID_PROXY_USER:          "TIBEMS_INVALID_PROXY_USER",
+	TIBEMS_INVALID_CERT:                "TIBEMS_INVALID_CERT",
+	TIBEMS_INVALID_CERT_NOT_YET:        "TIBEMS_INVALID_CERT_NOT_YET",
+	TIBEMS_INVALID_CERT_EXPIRED:        "TIBEMS_INVALID_CERT_EXPIRED",
+	TIBEMS_INVALID_CERT_DATA:           "TIBEMS_INVALID_CERT_DATA",
+	TIBEMS_ALGORITHM_ERROR:             "TIBEMS_ALGORITHM_ERROR",
+	TIBEMS_SSL_ERROR:                   "TIBEMS_SSL_ERROR",
+	TIBEMS_INVALID_PRIVATE_KEY:         "TIBEMS_INVALID_PRIVATE_KEY",
+	TIBEMS_INVALID_ENCODING:            "TIBEMS_INVALID_ENCODING",
+	TIBEMS_NOT_ENOUGH_RANDOM:           "TIBEMS_NOT_ENOUGH_RANDOM",
+	TIBEMS_INVALID_CRL_DATA:            "TIBEMS_INVALID_CRL_DATA",
+	TIBEMS_CRL_OFF:                     "TIBEMS_CRL_OFF",
+	TIBEMS_EMPTY_CRL:                   "TIBEMS_EMPTY_CRL",
+	TIBEMS_NOT_INITIALIZED:             "TIBEMS_NOT_INITIALIZED",
+	TIBEMS_INIT_FAILURE:                "TIBEMS_INIT_FAILURE",
+	TIBEMS_ARG_CONFLICT:                "TIBEMS_ARG_CONFLICT",
+	TIBEMS_SERVICE_NOT_FOUND:           "TIBEMS_SERVICE_NOT_FOUND",
+	TIBEMS_INVALID_CALLBACK:            "TIBEMS_INVALID_CALLBACK",
+	TIBEMS_INVALID_QUEUE:               "TIBEMS_INVALID_QUEUE",
+	TIBEMS_INVALID_EVENT:               "TIBEMS_INVALID_EVENT",
+	TIBEMS_INVALID_SUBJECT:             "TIBEMS_INVALID_SUBJECT",
+	TIBEMS_INVALID_DISPATCHER:          "TIBEMS_INVALID_DISPATCHER",
+	TIBEMS_JNI_EXCEPTION:               "TIBEMS_JNI_EXCEPTION",
+	TIBEMS_JNI_ERR:                     "TIBEMS_JNI_ERR",
+	TIBEMS_JNI_EDETACHED:               "TIBEMS_JNI_EDETACHED",
+	TIBEMS_JNI_EVERSION:                "TIBEMS_JNI_EVERSION",
+	TIBEMS_JNI_EEXIST:                  "TIBEMS_JNI_EEXIST",
+	TIBEMS_JNI_EINVAL:                  "TIBEMS_JNI_EINVAL",
+	TIBEMS_NO_MEMORY_FOR_OBJECT:        "TIBEMS_NO_MEMORY_FOR_OBJECT",
+	TIBEMS_UFO_CONNECTION_FAILURE:      "TIBEMS_UFO_CONNECTION_FAILURE",
+	TIBEMS_NOT_IMPLEMENTED:             "TIBEMS_NOT_IMPLEMENTED",
+}
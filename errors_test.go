@@ -0,0 +1,66 @@
+package ems
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestError_Is(t *testing.T) {
+
+	err := newError(TIBEMS_TIMEOUT, "receive timed out", "")
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("timeout not matched")
+	}
+
+	if errors.Is(err, ErrServerDisconnected) {
+		t.Fatalf("timeout matched disconnected")
+	}
+
+	wrapped := fmt.Errorf("receive: %w", err)
+	if !errors.Is(wrapped, ErrTimeout) {
+		t.Fatalf("wrapped timeout not matched")
+	}
+
+	var emsErr *Error
+	if !errors.As(wrapped, &emsErr) || emsErr.Status != TIBEMS_TIMEOUT {
+		t.Fatalf("bad status")
+	}
+}
+
+func TestError_Error(t *testing.T) {
+
+	err := newError(TIBEMS_SERVER_NOT_CONNECTED, "Failed to connect", "")
+	if err.Error() != "TIBEMS_SERVER_NOT_CONNECTED: Failed to connect" {
+		t.Fatalf("bad error string %q", err.Error())
+	}
+
+	if ErrTimeout.Error() != "TIBEMS_TIMEOUT" {
+		t.Fatalf("bad error string %q", ErrTimeout.Error())
+	}
+}
+
+func TestStatusName(t *testing.T) {
+
+	if StatusName(TIBEMS_DESTINATION_LIMIT_EXCEEDED) != "TIBEMS_DESTINATION_LIMIT_EXCEEDED" {
+		t.Fatalf("bad status name")
+	}
+
+	if StatusName(9999) != "TIBEMS_STATUS_9999" {
+		t.Fatalf("bad unknown status name")
+	}
+}
+
+func TestIsSSLError(t *testing.T) {
+
+	err := fmt.Errorf("connect: %w", newError(TIBEMS_INVALID_CERT_EXPIRED, "certificate expired", ""))
+
+	if !IsSSLError(err) || !errors.Is(err, ErrInvalidCertExpired) {
+		t.Fatalf("expired certificate not matched")
+	}
+
+	if IsSSLError(ErrTimeout) || IsSSLError(errors.New("ssl")) {
+		t.Fatalf("non SSL error matched")
+	}
+}
@@ -0,0 +1,89 @@
+package ems
+
+import "time"
+
+// ConnectionEventType is the kind of a ConnectionEvent.
+type ConnectionEventType int
+
+const (
+	// ConnectionDisconnected reports that the connection to the server was
+	// lost; a reconnecting event follows when reconnects are configured.
+	ConnectionDisconnected ConnectionEventType = iota + 1
+	// ConnectionReconnecting reports that the client is reconnecting,
+	// possibly to the other server of a fault-tolerant pair.
+	ConnectionReconnecting
+	// ConnectionReconnected reports that the connection was restored.
+	ConnectionReconnected
+	// ConnectionFatal reports a failure the connection cannot recover from.
+	// The client must Disconnect and Connect again.
+	ConnectionFatal
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionDisconnected:
+		return "disconnected"
+	case ConnectionReconnecting:
+		return "reconnecting"
+	case ConnectionReconnected:
+		return "reconnected"
+	case ConnectionFatal:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+// ConnectionEvent is an asynchronous change in the state of a connection.
+type ConnectionEvent struct {
+	Type ConnectionEventType
+	Err  error // the *Error reported by EMS
+	Time time.Time
+}
+
+// ConnectionEventListener is called for each connection event, on the EMS
+// thread that reported it, so it should return quickly.
+type ConnectionEventListener func(event ConnectionEvent)
+
+// connectionEventBuffer is the number of events Events holds for a slow reader.
+const connectionEventBuffer = 64
+
+// connectionEvents passes connection events to the listener set in the
+// client options and to the channel returned by Events.
+type connectionEvents struct {
+	listener ConnectionEventListener
+	ch       chan ConnectionEvent
+}
+
+func newConnectionEvents(listener ConnectionEventListener) *connectionEvents {
+	return &connectionEvents{
+		listener: listener,
+		ch:       make(chan ConnectionEvent, connectionEventBuffer),
+	}
+}
+
+// publish never blocks: events are dropped when the channel is full.
+func (e *connectionEvents) publish(event ConnectionEvent) {
+
+	if e.listener != nil {
+		e.listener(event)
+	}
+
+	select {
+	case e.ch <- event:
+	default:
+	}
+}
+
+// connectionEventType maps the status passed to the EMS exception listener
+// to its event type.
+func connectionEventType(status int) ConnectionEventType {
+	switch status {
+	case TIBEMS_SERVER_DISCONNECTED:
+		return ConnectionDisconnected
+	case TIBEMS_SERVER_RECONNECTING:
+		return ConnectionReconnecting
+	case TIBEMS_SERVER_RECONNECTED:
+		return ConnectionReconnected
+	}
+	return ConnectionFatal
+}
@@ -0,0 +1,73 @@
+package ems
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnectionEventType(t *testing.T) {
+
+	for status, want := range map[int]ConnectionEventType{
+		TIBEMS_SERVER_DISCONNECTED:    ConnectionDisconnected,
+		TIBEMS_SERVER_RECONNECTING:    ConnectionReconnecting,
+		TIBEMS_SERVER_RECONNECTED:     ConnectionReconnected,
+		TIBEMS_SERVER_NOT_CONNECTED:   ConnectionFatal,
+		TIBEMS_UFO_CONNECTION_FAILURE: ConnectionFatal,
+	} {
+		if got := connectionEventType(status); got != want {
+			t.Errorf("%s: got %v, want %v", StatusName(status), got, want)
+		}
+	}
+
+	if ConnectionReconnecting.String() != "reconnecting" {
+		t.Fatalf("bad event name %q", ConnectionReconnecting)
+	}
+}
+
+func TestConnectionEvents_Publish(t *testing.T) {
+
+	var heard []ConnectionEvent
+	events := newConnectionEvents(func(e ConnectionEvent) {
+		heard = append(heard, e)
+	})
+
+	event := ConnectionEvent{
+		Type: ConnectionDisconnected,
+		Err:  newError(TIBEMS_SERVER_DISCONNECTED, "", ""),
+		Time: time.Now(),
+	}
+
+	// a full channel drops events rather than blocking the EMS thread
+	for i := 0; i < connectionEventBuffer+10; i++ {
+		events.publish(event)
+	}
+
+	if len(heard) != connectionEventBuffer+10 {
+		t.Fatalf("listener heard %d events", len(heard))
+	}
+
+	if len(events.ch) != connectionEventBuffer {
+		t.Fatalf("channel holds %d events", len(events.ch))
+	}
+
+	e := <-events.ch
+	if e.Type != ConnectionDisconnected || !errors.Is(e.Err, ErrServerDisconnected) {
+		t.Fatalf("bad event %+v", e)
+	}
+}
+
+func TestMemoryClient_Events(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-events:7222")
+
+	if c.Events() == nil {
+		t.Fatalf("no event channel")
+	}
+
+	select {
+	case e := <-c.Events():
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+}
@@ -0,0 +1,80 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+
+extern void emsExceptionCallback(tibemsConnection connection, tibems_status status, void* closure);
+*/
+import "C"
+import (
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// connections maps EMS connections to their clients for the exception callback.
+var connections = struct {
+	sync.RWMutex
+	clients map[C.tibemsConnection]*Client
+}{clients: make(map[C.tibemsConnection]*Client)}
+
+var ftEvents sync.Once
+
+//export emsExceptionCallback
+func emsExceptionCallback(connection C.tibemsConnection, status C.tibems_status, closure unsafe.Pointer) {
+
+	connections.RLock()
+	c := connections.clients[connection]
+	connections.RUnlock()
+
+	if c == nil {
+		return
+	}
+
+	c.onException(status)
+}
+
+// setExceptionListener registers the client for exception callbacks on its connection.
+func (c *Client) setExceptionListener() C.tibems_status {
+
+	// report fault-tolerant disconnects and reconnects as well as fatal errors
+	ftEvents.Do(func() {
+		C.tibems_setExceptionOnFTEvents(TIBEMS_TRUE)
+	})
+
+	connections.Lock()
+	connections.clients[c.conn] = c
+	connections.Unlock()
+
+	return C.tibemsConnection_SetExceptionListener(c.conn, (C.tibemsExceptionCallback)(unsafe.Pointer(C.emsExceptionCallback)), nil)
+}
+
+func (c *Client) clearExceptionListener() {
+	connections.Lock()
+	delete(connections.clients, c.conn)
+	connections.Unlock()
+}
+
+// onException updates the connection status and notifies the application.
+func (c *Client) onException(status C.tibems_status) {
+
+	switch status {
+	case TIBEMS_SERVER_RECONNECTED:
+		c.setConnected(connected)
+	case TIBEMS_SERVER_DISCONNECTED, TIBEMS_SERVER_RECONNECTING:
+		c.setConnected(reconnecting)
+	default:
+		c.setConnected(disconnected)
+	}
+
+	err := c.newError(status)
+	c.options.logf("ems: connection exception: %v", err)
+
+	if c.options.exceptionListener != nil {
+		c.options.exceptionListener(err)
+	}
+
+	c.events.publish(ConnectionEvent{Type: connectionEventType(int(status)), Err: err, Time: time.Now()})
+}
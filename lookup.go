@@ -0,0 +1,139 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <stdlib.h>
+#include <tibems.h>
+*/
+import "C"
+import (
+	"context"
+	"unsafe"
+)
+
+// maxDestinationName is the buffer size for destination names read from EMS.
+const maxDestinationName = 1024
+
+// LookupDestination returns the name and type ("queue" or "topic") of the
+// destination bound to name in the server's lookup context, so applications
+// can be configured with administered names rather than physical ones.
+// A missing name gives an *Error matching ErrNotFound.
+func (c *Client) LookupDestination(name string) (string, string, error) {
+
+	user, pass, err := c.options.getCredentials(context.Background())
+	if err != nil {
+		return "", "", err
+	}
+
+	c.lookupLock.Lock()
+	defer c.lookupLock.Unlock()
+
+	if err := c.openLookupContext(user, pass); err != nil {
+		return "", "", err
+	}
+
+	var dest C.tibemsDestination
+	var destType C.tibemsDestinationType
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	status := C.tibemsLookupContext_LookupDestination(c.lookup, cname, &dest)
+	if status != TIBEMS_OK {
+		return "", "", c.newError(status)
+	}
+	defer C.tibemsDestination_Destroy(dest)
+
+	status = C.tibemsDestination_GetType(dest, &destType)
+	if status != TIBEMS_OK {
+		return "", "", c.newError(status)
+	}
+
+	buf := (*C.char)(C.calloc(maxDestinationName, 1))
+	defer C.free(unsafe.Pointer(buf))
+
+	status = C.tibemsDestination_GetName(dest, buf, maxDestinationName)
+	if status != TIBEMS_OK {
+		return "", "", c.newError(status)
+	}
+
+	if destType == TIBEMS_TOPIC {
+		return C.GoString(buf), "topic", nil
+	}
+	return C.GoString(buf), "queue", nil
+}
+
+// lookupConnectionFactory looks up the connection factory named in the options.
+func (c *Client) lookupConnectionFactory(username string, password string) error {
+
+	c.lookupLock.Lock()
+	defer c.lookupLock.Unlock()
+
+	if err := c.openLookupContext(username, password); err != nil {
+		return err
+	}
+
+	name := C.CString(c.options.connectionFactory)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsLookupContext_LookupConnectionFactory(c.lookup, name, &c.cf)
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+// openLookupContext connects the lookup context to the server, unless it is
+// already open. c.lookupLock must be held.
+func (c *Client) openLookupContext(username string, password string) error {
+
+	if c.lookup != nil {
+		return nil
+	}
+
+	url := C.CString(c.options.lookupURLString())
+	defer C.free(unsafe.Pointer(url))
+
+	user := C.CString(username)
+	defer C.free(unsafe.Pointer(user))
+
+	pass := C.CString(password)
+	defer C.free(unsafe.Pointer(pass))
+
+	var status C.tibems_status
+
+	if c.options.usesSSL() {
+		params, err := c.sslParams()
+		if err != nil {
+			return err
+		}
+		defer C.tibemsSSLParams_Destroy(params)
+
+		pkPass := C.CString(c.options.ssl.privateKeyPass)
+		defer C.free(unsafe.Pointer(pkPass))
+
+		status = C.tibemsLookupContext_CreateSSL(&c.lookup, url, user, pass, params, pkPass)
+	} else {
+		status = C.tibemsLookupContext_Create(&c.lookup, url, user, pass)
+	}
+
+	if status != TIBEMS_OK {
+		c.lookup = nil
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+func (c *Client) closeLookupContext() {
+
+	c.lookupLock.Lock()
+	defer c.lookupLock.Unlock()
+
+	if c.lookup != nil {
+		C.tibemsLookupContext_Destroy(c.lookup)
+		c.lookup = nil
+	}
+}
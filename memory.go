@@ -0,0 +1,1267 @@
+package ems
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memoryBrokers holds the in-memory brokers by server URL, so clients
+// created with the same URL share queues and topics.
+var memoryBrokers = struct {
+	sync.Mutex
+	brokers map[string]*memoryBroker
+}{brokers: make(map[string]*memoryBroker)}
+
+// memoryBroker is a pure Go stand-in for an EMS server. Queues deliver each
+// message to one consumer; topics deliver a copy to every subscriber present
+// when the message is published.
+type memoryBroker struct {
+	queues        map[string]*memoryQueue
+	topics        map[string]map[*memoryQueue]struct{}
+	subscriptions map[string]*memoryTopicSubscription
+	names         map[string][2]string // bound destination name and type
+	seq           uint64
+	sync.Mutex
+}
+
+// memoryTopicSubscription is a durable or shared topic subscription, whose
+// queue is shared by its consumers and, when durable, outlives them.
+type memoryTopicSubscription struct {
+	destination string
+	selector    string
+	durable     bool
+	queue       *memoryQueue
+	consumers   int
+}
+
+// memoryEntry is a message held by the broker.
+type memoryEntry struct {
+	msg       Message
+	deliverAt time.Time
+	expiresAt time.Time
+}
+
+// memoryQueue is a queue, or the buffer of a single topic subscriber.
+type memoryQueue struct {
+	entries []*memoryEntry
+	changed chan struct{}
+	filter  *selector // selector of a topic subscriber
+}
+
+// MemoryClient implements IClient against an in-memory broker, for testing
+// code that uses this package without an EMS server.
+type MemoryClient struct {
+	broker        *memoryBroker
+	status        uint32
+	done          chan struct{}
+	options       ClientOptions
+	subscriptions map[*memorySubscription]struct{}
+	sessions      map[*memorySession]struct{}
+	transactions  map[*memoryTx]struct{}
+	replyTo       string
+	router        *replyRouter
+	events        *connectionEvents
+	sync.Mutex
+}
+
+type memorySubscription struct {
+	client  *MemoryClient
+	queue   *memoryQueue
+	session *memorySession
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	closeFn func()
+}
+
+// memorySession holds the messages delivered by a consumer in a client
+// acknowledge mode, or by a transaction, until they are acknowledged or
+// recovered.
+type memorySession struct {
+	client  *MemoryClient
+	mode    int
+	single  bool // the session of a single receive, ended by Ack or Recover
+	unacked []memoryDelivery
+	sync.Mutex
+}
+
+// memoryDelivery is a delivered entry and the queue it is returned to if
+// it is recovered.
+type memoryDelivery struct {
+	queue *memoryQueue
+	entry *memoryEntry
+}
+
+// memoryAck acknowledges or recovers one delivered message.
+type memoryAck struct {
+	session *memorySession
+	entry   *memoryEntry
+}
+
+// NewMemoryClient returns a client backed by an in-memory broker. Clients
+// created with the same server URL share the same broker.
+func NewMemoryClient(o *ClientOptions) IClient {
+
+	key := o.serverURLString()
+
+	memoryBrokers.Lock()
+	b, ok := memoryBrokers.brokers[key]
+	if !ok {
+		b = &memoryBroker{
+			queues:        make(map[string]*memoryQueue),
+			topics:        make(map[string]map[*memoryQueue]struct{}),
+			subscriptions: make(map[string]*memoryTopicSubscription),
+		}
+		memoryBrokers.brokers[key] = b
+	}
+	memoryBrokers.Unlock()
+
+	return &MemoryClient{
+		broker:  b,
+		status:  disconnected,
+		options: *o,
+		events:  newConnectionEvents(o.eventListener),
+	}
+}
+
+func (c *MemoryClient) IsConnected() bool {
+	return atomic.LoadUint32(&c.status) == connected
+}
+
+// Events returns the client's connection event channel. The in-memory broker
+// never fails, so no events are sent.
+func (c *MemoryClient) Events() <-chan ConnectionEvent {
+	return c.events.ch
+}
+
+func (c *MemoryClient) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+func (c *MemoryClient) ConnectContext(ctx context.Context) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.options.Validate(); err != nil {
+		return err
+	}
+
+	// the broker does not authenticate, but a failing provider fails Connect
+	if _, _, err := c.options.getCredentials(ctx); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.done == nil {
+		c.done = make(chan struct{})
+		c.options.logf("ems: connected to in-memory broker %s", c.options.serverURLString())
+	}
+	atomic.StoreUint32(&c.status, connected)
+
+	return nil
+}
+
+func (c *MemoryClient) Disconnect() error {
+
+	c.Lock()
+	subs := c.subscriptions
+	c.subscriptions = nil
+	sessions := c.sessions
+	c.sessions = nil
+	txs := c.transactions
+	c.transactions = nil
+	if c.router != nil {
+		c.router.close()
+		c.broker.deleteQueue(c.replyTo)
+		c.replyTo, c.router = "", nil
+	}
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+		c.options.logf("ems: disconnected from in-memory broker %s", c.options.serverURLString())
+	}
+	atomic.StoreUint32(&c.status, disconnected)
+	c.Unlock()
+
+	for s := range subs {
+		s.close()
+	}
+
+	for t := range txs {
+		t.close()
+	}
+
+	// unacknowledged messages are redelivered to other consumers
+	for s := range sessions {
+		s.recover()
+	}
+
+	return nil
+}
+
+func (c *MemoryClient) Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+	return c.SendMessageContext(context.Background(), destination, destinationType, NewMessage(message), deliveryDelay, deliveryMode, expiration)
+}
+
+func (c *MemoryClient) SendContext(ctx context.Context, destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+	return c.SendMessageContext(ctx, destination, destinationType, NewMessage(message), deliveryDelay, deliveryMode, expiration)
+}
+
+func (c *MemoryClient) SendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+	return c.SendMessageContext(context.Background(), destination, destinationType, message, deliveryDelay, deliveryMode, expiration)
+}
+
+func (c *MemoryClient) SendMessageContext(ctx context.Context, destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	message, err := checkMessage(message)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.connection(); err != nil {
+		return err
+	}
+
+	c.broker.publish(destination, isTopic(destinationType), message, deliveryDelay, expiration)
+
+	return nil
+}
+
+func (c *MemoryClient) SendReceive(destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error) {
+	return c.SendReceiveContext(context.Background(), destination, destinationType, message, deliveryMode, expiration)
+}
+
+// SendReceiveContext sends a text request and waits for its reply, as
+// RequestContext does, until ctx is done or, if ctx has no deadline, for 30
+// seconds. It returns an error matching ErrTimeout if no reply arrives.
+func (c *MemoryClient) SendReceiveContext(ctx context.Context, destination string, destinationType string, message string, deliveryMode string, expiration int) (string, error) {
+
+	reply, timedOut, err := c.RequestContext(ctx, destination, destinationType, NewMessage(message), sendReceiveWait(ctx), deliveryMode, expiration)
+	if err != nil {
+		return "", err
+	}
+	if timedOut {
+		return "", errNoReply
+	}
+
+	return textBody(reply)
+}
+
+func (c *MemoryClient) Request(destination string, destinationType string, request Message, timeout int, deliveryMode string, expiration int) (Message, bool, error) {
+	return c.RequestContext(context.Background(), destination, destinationType, request, timeout, deliveryMode, expiration)
+}
+
+// RequestContext sends the request with the client's temporary reply queue
+// and a new CorrelationID, replacing any set on request, then waits up to
+// timeout milliseconds for the reply whose CorrelationID is that ID or the
+// request's MessageID.
+func (c *MemoryClient) RequestContext(ctx context.Context, destination string, destinationType string, request Message, timeout int, deliveryMode string, expiration int) (Message, bool, error) {
+
+	if err := ctx.Err(); err != nil {
+		return Message{}, false, err
+	}
+
+	request, err := checkMessage(request)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	replyTo, router, err := c.replyQueue()
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	id, replies := router.register()
+	defer router.remove(id)
+
+	request.CorrelationID = id
+	request.ReplyTo = replyTo
+	request.ReplyToType = "queue"
+
+	router.send(id, func() (string, error) {
+		return c.broker.publish(destination, isTopic(destinationType), request, 0, expiration), nil
+	})
+
+	return router.wait(ctx, replies, timeout)
+}
+
+// replyQueue returns the client's temporary reply queue, creating it and the
+// goroutine routing its replies on first use.
+func (c *MemoryClient) replyQueue() (string, *replyRouter, error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.done == nil {
+		return "", nil, newError(TIBEMS_INVALID_CONNECTION, "not connected", "")
+	}
+
+	if c.router == nil {
+		name, queue := c.broker.createTemporaryQueue()
+		router := newReplyRouter(c.options.logf)
+		done := c.done
+
+		go func() {
+			for {
+				reply, _, err := c.broker.receive(context.Background(), done, queue, nil, nil, 0)
+				if err != nil {
+					return
+				}
+				router.deliver(reply)
+			}
+		}()
+
+		c.replyTo, c.router = name, router
+	}
+
+	return c.replyTo, c.router, nil
+}
+
+func (c *MemoryClient) Receive(destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error) {
+	return c.ReceiveContext(context.Background(), destination, destinationType, timeout, opts...)
+}
+
+func (c *MemoryClient) ReceiveContext(ctx context.Context, destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error) {
+
+	msg, timedOut, err := c.ReceiveMessageContext(ctx, destination, destinationType, timeout, opts...)
+	if err != nil || timedOut {
+		return "", timedOut, err
+	}
+
+	body, err := textBody(msg)
+	return body, false, err
+}
+
+func (c *MemoryClient) ReceiveMessage(destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error) {
+	return c.ReceiveMessageContext(context.Background(), destination, destinationType, timeout, opts...)
+}
+
+func (c *MemoryClient) ReceiveMessageContext(ctx context.Context, destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error) {
+
+	if err := ctx.Err(); err != nil {
+		return Message{}, false, err
+	}
+
+	o, err := newMemoryConsumerOptions(destinationType, opts)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	done, err := c.connection()
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	// like a consumer created for one receive, a topic receive only sees
+	// messages published while it waits
+	queue, release, err := c.broker.consumerQueue(destination, isTopic(destinationType), o, c.options.clientID)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer release()
+
+	session := c.newSession(o, true)
+
+	return c.broker.receive(ctx, done, queue, o.filter, session, timeout)
+}
+
+func (c *MemoryClient) Subscribe(destination string, destinationType string, handler MessageHandler, opts ...ConsumerOption) (ISubscription, error) {
+
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+
+	o, err := newMemoryConsumerOptions(destinationType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	queue, release, err := c.broker.consumerQueue(destination, isTopic(destinationType), o, c.options.clientID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &memorySubscription{
+		client:  c,
+		queue:   queue,
+		session: c.newSession(o, false),
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+		closeFn: release,
+	}
+
+	go func() {
+		defer close(s.stopped)
+		for {
+			msg, _, err := c.broker.receive(ctx, done, queue, o.filter, s.session, 0)
+			if err != nil {
+				return
+			}
+			handler(msg)
+		}
+	}()
+
+	c.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[*memorySubscription]struct{})
+	}
+	c.subscriptions[s] = struct{}{}
+	c.Unlock()
+
+	return s, nil
+}
+
+// Serve answers the requests sent to a queue with handler, as Client.Serve does.
+func (c *MemoryClient) Serve(destination string, handler RequestHandler, concurrency int, opts ...ConsumerOption) (ISubscription, error) {
+	return serve(c, destination, handler, concurrency, opts)
+}
+
+func (c *MemoryClient) Unsubscribe(name string) error {
+
+	if _, err := c.connection(); err != nil {
+		return err
+	}
+
+	return c.broker.unsubscribe(c.options.clientID, name)
+}
+
+// Metadata describes the in-memory connection; the active URL is the first
+// server URL.
+func (c *MemoryClient) Metadata() (ConnectionMetadata, error) {
+
+	if _, err := c.connection(); err != nil {
+		return ConnectionMetadata{}, err
+	}
+
+	urls := strings.Split(c.options.serverURLString(), ",")
+
+	return ConnectionMetadata{
+		ClientID:      c.options.clientID,
+		ProviderName:  "in-memory",
+		ActiveURL:     urls[0],
+		ServerURLs:    urls,
+		FaultTolerant: len(urls) > 1,
+	}, nil
+}
+
+// BindDestination binds name to a destination on the in-memory broker, as an
+// administrator would in the EMS server's lookup context, for LookupDestination.
+func (c *MemoryClient) BindDestination(name string, destination string, destinationType string) {
+
+	c.broker.Lock()
+	defer c.broker.Unlock()
+
+	if c.broker.names == nil {
+		c.broker.names = make(map[string][2]string)
+	}
+	c.broker.names[name] = [2]string{destination, strings.ToLower(destinationType)}
+}
+
+// LookupDestination returns the destination bound to name by BindDestination.
+func (c *MemoryClient) LookupDestination(name string) (string, string, error) {
+
+	if _, err := c.connection(); err != nil {
+		return "", "", err
+	}
+
+	c.broker.Lock()
+	defer c.broker.Unlock()
+
+	d, ok := c.broker.names[name]
+	if !ok {
+		return "", "", newError(TIBEMS_NOT_FOUND, fmt.Sprintf("destination %q is not bound", name), "")
+	}
+
+	return d[0], d[1], nil
+}
+
+// Browse returns a browser over a snapshot of the messages deliverable from
+// the queue, in the order they would be received.
+func (c *MemoryClient) Browse(queue string, opts ...ConsumerOption) (IQueueBrowser, error) {
+
+	o, err := newMemoryConsumerOptions("queue", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.connection(); err != nil {
+		return nil, err
+	}
+
+	return &memoryBrowser{msgs: c.broker.browse(queue, o.filter)}, nil
+}
+
+// memoryBrowser iterates over a snapshot of a queue.
+type memoryBrowser struct {
+	msgs   []Message
+	closed bool
+	sync.Mutex
+}
+
+func (b *memoryBrowser) Next() (Message, bool, error) {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return Message{}, false, newError(TIBEMS_ILLEGAL_STATE, "browser is closed", "")
+	}
+
+	if len(b.msgs) == 0 {
+		return Message{}, false, nil
+	}
+
+	m := b.msgs[0]
+	b.msgs = b.msgs[1:]
+
+	return m, true, nil
+}
+
+func (b *memoryBrowser) Close() error {
+	b.Lock()
+	b.closed = true
+	b.msgs = nil
+	b.Unlock()
+	return nil
+}
+
+// Close stops delivery, waiting for a handler call in progress.
+func (s *memorySubscription) Close() error {
+
+	s.client.Lock()
+	delete(s.client.subscriptions, s)
+	s.client.Unlock()
+
+	s.close()
+	return nil
+}
+
+func (s *memorySubscription) close() {
+	s.cancel()
+	<-s.stopped
+
+	// closing the session redelivers its unacknowledged messages
+	if s.session != nil {
+		s.client.removeSession(s.session)
+		s.session.recover()
+	}
+
+	s.closeFn()
+}
+
+// newSession returns the session tracking unacknowledged messages for a
+// consumer in a client acknowledge mode, or nil in the other modes.
+func (c *MemoryClient) newSession(o *consumerOptions, single bool) *memorySession {
+
+	if !o.clientAcknowledge() {
+		return nil
+	}
+
+	s := &memorySession{client: c, mode: o.ackMode, single: single}
+
+	// a single receive session is registered once it holds a message
+	if !single {
+		c.addSession(s)
+	}
+
+	return s
+}
+
+func (c *MemoryClient) addSession(s *memorySession) {
+	c.Lock()
+	if c.sessions == nil {
+		c.sessions = make(map[*memorySession]struct{})
+	}
+	c.sessions[s] = struct{}{}
+	c.Unlock()
+}
+
+func (c *MemoryClient) removeSession(s *memorySession) {
+	c.Lock()
+	delete(c.sessions, s)
+	c.Unlock()
+}
+
+// deliver records an entry taken from the queue as unacknowledged and
+// returns its message.
+func (s *memorySession) deliver(q *memoryQueue, e *memoryEntry) Message {
+
+	s.Lock()
+	s.unacked = append(s.unacked, memoryDelivery{queue: q, entry: e})
+	s.Unlock()
+
+	if s.single {
+		s.client.addSession(s)
+	}
+
+	m := copyMessage(e.msg)
+	m.acker = memoryAck{session: s, entry: e}
+
+	return m
+}
+
+func (s *memorySession) ack(e *memoryEntry) error {
+
+	s.Lock()
+
+	i := -1
+	for j, u := range s.unacked {
+		if u.entry == e {
+			i = j
+			break
+		}
+	}
+
+	if i < 0 {
+		s.Unlock()
+		return newError(TIBEMS_ILLEGAL_STATE, "message already acknowledged or recovered", "")
+	}
+
+	// client acknowledge mode acknowledges every message of the session
+	if s.mode == TIBEMS_CLIENT_ACKNOWLEDGE {
+		s.unacked = nil
+	} else {
+		s.unacked = append(s.unacked[:i], s.unacked[i+1:]...)
+	}
+	ended := s.single && len(s.unacked) == 0
+
+	s.Unlock()
+
+	if ended {
+		s.client.removeSession(s)
+	}
+
+	return nil
+}
+
+// recover puts the unacknowledged messages back on the queue, marked as
+// redelivered.
+func (s *memorySession) recover() {
+
+	s.Lock()
+	deliveries := s.unacked
+	s.unacked = nil
+	s.Unlock()
+
+	if s.single {
+		s.client.removeSession(s)
+	}
+
+	b := s.client.broker
+	b.Lock()
+	for _, d := range deliveries {
+		d.entry.msg.Redelivered = true
+		d.queue.put(d.entry)
+	}
+	b.Unlock()
+}
+
+func (a memoryAck) ack() error {
+	return a.session.ack(a.entry)
+}
+
+func (a memoryAck) recover() error {
+	a.session.recover()
+	return nil
+}
+
+// connection returns the channel closed on Disconnect, or an error if the
+// client is not connected.
+func (c *MemoryClient) connection() (chan struct{}, error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.done == nil {
+		return nil, newError(TIBEMS_INVALID_CONNECTION, "not connected", "")
+	}
+
+	return c.done, nil
+}
+
+// publish stores a copy of the message on the queue, or on the buffer of
+// every current subscriber to the topic, and returns its MessageID.
+func (b *memoryBroker) publish(destination string, topic bool, message Message, deliveryDelay int, expiration int) string {
+
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+
+	b.seq++
+	message.MessageID = fmt.Sprintf("ID:MEMORY.%d", b.seq)
+	message.acker = nil
+	message.Timestamp = now
+	message.Redelivered = false
+
+	newEntry := func() *memoryEntry {
+		e := &memoryEntry{msg: copyMessage(message)}
+		if deliveryDelay > 0 {
+			e.deliverAt = now.Add(time.Duration(deliveryDelay) * time.Millisecond)
+		}
+		if expiration > 0 {
+			e.expiresAt = now.Add(time.Duration(expiration) * time.Millisecond)
+		}
+		return e
+	}
+
+	if topic {
+		for q := range b.topics[destination] {
+			if q.filter.match(&message) {
+				q.put(newEntry())
+			}
+		}
+		return message.MessageID
+	}
+
+	b.queue(destination).put(newEntry())
+
+	return message.MessageID
+}
+
+// consumerQueue returns the queue a consumer of the destination reads from,
+// and a func to call when the consumer is closed. A topic consumer is only
+// given the messages matching its selector.
+func (b *memoryBroker) consumerQueue(destination string, topic bool, o *consumerOptions, clientID string) (*memoryQueue, func(), error) {
+
+	b.Lock()
+	defer b.Unlock()
+
+	if !topic {
+		return b.queue(destination), func() {}, nil
+	}
+
+	if o.subscription == "" {
+		q := b.subscribe(destination, o.filter)
+		return q, func() {
+			b.Lock()
+			delete(b.topics[destination], q)
+			b.Unlock()
+		}, nil
+	}
+
+	key := subscriptionKey(clientID, o)
+	s := b.subscriptions[key]
+
+	// changing the topic or selector of a subscription replaces it
+	if s != nil && (s.destination != destination || s.selector != o.selector) {
+		if s.consumers > 0 {
+			return nil, nil, newError(TIBEMS_ILLEGAL_STATE, "subscription "+o.subscription+" is in use", "")
+		}
+		b.removeSubscription(key, s)
+		s = nil
+	}
+
+	if s != nil && !o.shared && s.consumers > 0 {
+		return nil, nil, newError(TIBEMS_ILLEGAL_STATE, "durable subscription "+o.subscription+" is in use", "")
+	}
+
+	if s == nil {
+		s = &memoryTopicSubscription{
+			destination: destination,
+			selector:    o.selector,
+			durable:     o.durable,
+			queue:       b.subscribe(destination, o.filter),
+		}
+		b.subscriptions[key] = s
+	}
+	s.consumers++
+
+	return s.queue, func() {
+		b.Lock()
+		s.consumers--
+		if s.consumers == 0 && !s.durable {
+			b.removeSubscription(key, s)
+		}
+		b.Unlock()
+	}, nil
+}
+
+// subscribe adds a subscriber buffer to the topic. b must be locked.
+func (b *memoryBroker) subscribe(destination string, filter *selector) *memoryQueue {
+
+	q := newMemoryQueue()
+	q.filter = filter
+	if b.topics[destination] == nil {
+		b.topics[destination] = make(map[*memoryQueue]struct{})
+	}
+	b.topics[destination][q] = struct{}{}
+
+	return q
+}
+
+// removeSubscription deletes a durable or shared subscription. b must be locked.
+func (b *memoryBroker) removeSubscription(key string, s *memoryTopicSubscription) {
+	delete(b.topics[s.destination], s.queue)
+	delete(b.subscriptions, key)
+}
+
+// unsubscribe deletes the durable or shared durable subscription with the name.
+func (b *memoryBroker) unsubscribe(clientID string, name string) error {
+
+	b.Lock()
+	defer b.Unlock()
+
+	for _, o := range []*consumerOptions{
+		{subscription: name, durable: true},
+		{subscription: name, durable: true, shared: true},
+	} {
+		key := subscriptionKey(clientID, o)
+		if s := b.subscriptions[key]; s != nil {
+			if s.consumers > 0 {
+				return newError(TIBEMS_ILLEGAL_STATE, "subscription "+name+" is in use", "")
+			}
+			b.removeSubscription(key, s)
+			return nil
+		}
+	}
+
+	return newError(TIBEMS_INVALID_DESTINATION, "no durable subscription "+name, "")
+}
+
+func subscriptionKey(clientID string, o *consumerOptions) string {
+	return fmt.Sprintf("%t/%t/%s/%s", o.shared, o.durable, clientID, o.subscription)
+}
+
+// browse returns the messages deliverable from the queue that match filter,
+// highest priority first.
+func (b *memoryBroker) browse(name string, filter *selector) []Message {
+
+	b.Lock()
+	defer b.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+
+	var msgs []Message
+	for _, e := range q.entries {
+		if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			continue
+		}
+		if now.Before(e.deliverAt) || !filter.match(&e.msg) {
+			continue
+		}
+		msgs = append(msgs, copyMessage(e.msg))
+	}
+
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Priority > msgs[j].Priority
+	})
+
+	return msgs
+}
+
+func (b *memoryBroker) createTemporaryQueue() (string, *memoryQueue) {
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.seq++
+	name := fmt.Sprintf("$TMP$.MEMORY.%d", b.seq)
+
+	return name, b.queue(name)
+}
+
+func (b *memoryBroker) deleteQueue(name string) {
+	b.Lock()
+	delete(b.queues, name)
+	b.Unlock()
+}
+
+// queue returns the named queue, creating it on first use. b must be locked.
+func (b *memoryBroker) queue(name string) *memoryQueue {
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = newMemoryQueue()
+		b.queues[name] = q
+	}
+
+	return q
+}
+
+// receive waits up to timeout milliseconds for a message on q matching
+// filter; a timeout of zero or less waits until ctx is done or the client
+// disconnects. A non-nil session keeps the message until it is acknowledged.
+func (b *memoryBroker) receive(ctx context.Context, done chan struct{}, q *memoryQueue, filter *selector, session *memorySession, timeout int) (Message, bool, error) {
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	for {
+		b.Lock()
+		e, wake := q.take(time.Now(), filter)
+		changed := q.changed
+		b.Unlock()
+
+		if e != nil && session != nil {
+			return session.deliver(q, e), false, nil
+		}
+
+		if e != nil {
+			return e.msg, false, nil
+		}
+
+		// wake up when a delayed message becomes deliverable
+		var delayed <-chan time.Time
+		var t *time.Timer
+		if !wake.IsZero() {
+			t = time.NewTimer(time.Until(wake))
+			delayed = t.C
+		}
+
+		select {
+		case <-changed:
+		case <-delayed:
+		case <-expired:
+			return Message{}, true, nil
+		case <-ctx.Done():
+			return Message{}, false, ctx.Err()
+		case <-done:
+			return Message{}, false, newError(TIBEMS_INVALID_CONNECTION, "connection closed", "")
+		}
+
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{changed: make(chan struct{})}
+}
+
+// put adds an entry and wakes any waiting receivers. The broker must be locked.
+func (q *memoryQueue) put(e *memoryEntry) {
+	q.entries = append(q.entries, e)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// take removes and returns the highest priority deliverable entry matching
+// filter, oldest first within a priority, discarding expired entries. When
+// nothing is deliverable it returns the time the next delayed entry becomes
+// due. The broker must be locked.
+func (q *memoryQueue) take(now time.Time, filter *selector) (*memoryEntry, time.Time) {
+
+	var wake time.Time
+	best := -1
+
+	entries := q.entries[:0]
+	for _, e := range q.entries {
+		if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			continue
+		}
+		entries = append(entries, e)
+
+		if !filter.match(&e.msg) {
+			continue
+		}
+
+		if now.Before(e.deliverAt) {
+			if wake.IsZero() || e.deliverAt.Before(wake) {
+				wake = e.deliverAt
+			}
+			continue
+		}
+
+		if best < 0 || e.msg.Priority > entries[best].msg.Priority {
+			best = len(entries) - 1
+		}
+	}
+	q.entries = entries
+
+	if best < 0 {
+		return nil, wake
+	}
+
+	e := q.entries[best]
+	q.entries = append(q.entries[:best], q.entries[best+1:]...)
+
+	return e, time.Time{}
+}
+
+// checkMessage rejects body types the server does not accept and copies the
+// map or stream body, as the C client does when it builds the message.
+func checkMessage(message Message) (Message, error) {
+
+	switch message.BodyType {
+	case "":
+		message.BodyType = BodyTypeText
+	case BodyTypeText, BodyTypeBytes, BodyTypeMessage:
+	case BodyTypeMap:
+		fields, err := copyMap(message.Map)
+		if err != nil {
+			return Message{}, err
+		}
+		message.Map = fields
+	case BodyTypeStream:
+		values, err := copyStream(message.Stream)
+		if err != nil {
+			return Message{}, err
+		}
+		message.Stream = values
+	default:
+		return Message{}, errors.New("Unable to send message type " + message.BodyType)
+	}
+
+	if message.Priority == 0 {
+		message.Priority = DefaultPriority
+	}
+
+	return message, nil
+}
+
+// newMemoryConsumerOptions applies opts as newConsumerOptions does and parses
+// the selector, which the in-memory broker evaluates itself.
+func newMemoryConsumerOptions(destinationType string, opts []ConsumerOption) (*consumerOptions, error) {
+
+	o, err := newConsumerOptions(destinationType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.filter, err = parseSelector(o.selector); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func copyMessage(m Message) Message {
+
+	props := make(map[string]interface{}, len(m.Properties))
+	for k, v := range m.Properties {
+		props[k] = v
+	}
+	m.Properties = props
+
+	if m.Bytes != nil {
+		m.Bytes = append([]byte(nil), m.Bytes...)
+	}
+
+	// the fields were validated when the message was sent
+	if m.Map != nil {
+		m.Map, _ = copyMap(m.Map)
+	}
+
+	if m.Stream != nil {
+		m.Stream, _ = copyStream(m.Stream)
+	}
+
+	return m
+}
+
+func isTopic(destinationType string) bool {
+	return strings.ToUpper(destinationType) == "TOPIC"
+}
+
+// memoryTx is a transaction on the in-memory broker. Sends are held until
+// Commit, and received messages are kept by a session so Rollback can
+// redeliver them.
+type memoryTx struct {
+	client  *MemoryClient
+	session *memorySession
+	sends   []memorySend
+	closed  bool
+	sync.Mutex
+}
+
+// memorySend is a send held by a transaction.
+type memorySend struct {
+	destination   string
+	topic         bool
+	message       Message
+	deliveryDelay int
+	expiration    int
+}
+
+func (c *MemoryClient) Begin() (ITx, error) {
+
+	if _, err := c.connection(); err != nil {
+		return nil, err
+	}
+
+	t := &memoryTx{
+		client:  c,
+		session: &memorySession{client: c, mode: TIBEMS_SESSION_TRANSACTED},
+	}
+
+	c.Lock()
+	if c.transactions == nil {
+		c.transactions = make(map[*memoryTx]struct{})
+	}
+	c.transactions[t] = struct{}{}
+	c.Unlock()
+
+	return t, nil
+}
+
+func (t *memoryTx) Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+	return t.SendMessage(destination, destinationType, NewMessage(message), deliveryDelay, deliveryMode, expiration)
+}
+
+func (t *memoryTx) SendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	message, err := checkMessage(message)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return errTxClosed
+	}
+
+	t.sends = append(t.sends, memorySend{
+		destination:   destination,
+		topic:         isTopic(destinationType),
+		message:       copyMessage(message),
+		deliveryDelay: deliveryDelay,
+		expiration:    expiration,
+	})
+
+	return nil
+}
+
+func (t *memoryTx) Receive(destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error) {
+
+	msg, timedOut, err := t.ReceiveMessage(destination, destinationType, timeout, opts...)
+	if err != nil || timedOut {
+		return "", timedOut, err
+	}
+
+	body, err := textBody(msg)
+	return body, false, err
+}
+
+func (t *memoryTx) ReceiveMessage(destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error) {
+
+	if timeout <= 0 {
+		return Message{}, false, errTxTimeout
+	}
+
+	o, err := newMemoryConsumerOptions(destinationType, opts)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return Message{}, false, errTxClosed
+	}
+
+	done, err := t.client.connection()
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	queue, release, err := t.client.broker.consumerQueue(destination, isTopic(destinationType), o, t.client.options.clientID)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer release()
+
+	msg, timedOut, err := t.client.broker.receive(context.Background(), done, queue, o.filter, t.session, timeout)
+
+	// the message is acknowledged by Commit
+	msg.acker = nil
+
+	return msg, timedOut, err
+}
+
+func (t *memoryTx) Commit() error {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return errTxClosed
+	}
+
+	if _, err := t.client.connection(); err != nil {
+		return err
+	}
+
+	for _, s := range t.sends {
+		t.client.broker.publish(s.destination, s.topic, s.message, s.deliveryDelay, s.expiration)
+	}
+	t.sends = nil
+
+	t.session.Lock()
+	t.session.unacked = nil
+	t.session.Unlock()
+
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return errTxClosed
+	}
+
+	t.sends = nil
+	t.session.recover()
+
+	return nil
+}
+
+func (t *memoryTx) Close() error {
+
+	t.client.Lock()
+	delete(t.client.transactions, t)
+	t.client.Unlock()
+
+	t.close()
+	return nil
+}
+
+// close rolls back uncommitted work.
+func (t *memoryTx) close() {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+
+	t.sends = nil
+	t.session.recover()
+}
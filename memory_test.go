@@ -0,0 +1,945 @@
+package ems
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMemoryTestClient(t *testing.T, url string) IClient {
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl(url))
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.Disconnect() })
+
+	return c
+}
+
+func TestMemoryClient_SendReceive(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-send-receive:7222")
+
+	msg := NewMessage("hello, world")
+	msg.CorrelationID = "correlation-1"
+	msg.SetProperty("count", int32(1))
+
+	if err := c.SendMessage("queue.sample", "queue", msg, 0, "non_persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	received, timeout, err := c.ReceiveMessage("queue.sample", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout {
+		t.Fatalf("unexpected timeout")
+	}
+
+	if received.Body != "hello, world" || received.CorrelationID != "correlation-1" {
+		t.Fatalf("bad message %+v", received)
+	}
+
+	if received.Properties["count"] != int32(1) {
+		t.Fatalf("bad property")
+	}
+
+	if received.MessageID == "" || received.Timestamp.IsZero() {
+		t.Fatalf("message id and timestamp not set")
+	}
+
+	_, timeout, err = c.Receive("queue.sample", "queue", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !timeout {
+		t.Fatalf("expected timeout on empty queue")
+	}
+}
+
+func TestMemoryClient_SharedBroker(t *testing.T) {
+
+	producer := newMemoryTestClient(t, "tcp://memory-shared:7222")
+	consumer := newMemoryTestClient(t, "tcp://memory-shared:7222")
+
+	if err := producer.Send("queue.shared", "queue", "hello", 0, "persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _, err := consumer.Receive("queue.shared", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body != "hello" {
+		t.Fatalf("bad body %q", body)
+	}
+}
+
+func TestMemoryClient_InvalidURL(t *testing.T) {
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl("tcp://memory-invalid:7222,tcp://bad host:7222"))
+
+	if err := c.Connect(); err == nil || !strings.Contains(err.Error(), "invalid server URL") {
+		t.Fatalf("expected invalid server URL, got %v", err)
+	}
+
+	c = NewMemoryClient(NewClientOptions().SetServerUrl("ftp://memory-invalid:7222"))
+
+	if err := c.Connect(); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Fatalf("expected unsupported scheme, got %v", err)
+	}
+}
+
+func TestMemoryClient_Priority(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-priority:7222")
+
+	low := NewMessage("low")
+	low.Priority = 1
+	high := NewMessage("high")
+	high.Priority = 9
+
+	c.SendMessage("queue.priority", "queue", low, 0, "non_persistent", 0)
+	c.SendMessage("queue.priority", "queue", high, 0, "non_persistent", 0)
+
+	body, _, _ := c.Receive("queue.priority", "queue", 1000)
+	if body != "high" {
+		t.Fatalf("expected high priority message first, got %q", body)
+	}
+
+	// a message literal goes out at the default priority, ahead of low
+	c.SendMessage("queue.priority", "queue", Message{Body: "literal"}, 0, "non_persistent", 0)
+
+	msg, _, _ := c.ReceiveMessage("queue.priority", "queue", 1000)
+	if msg.Body != "literal" || msg.Priority != DefaultPriority {
+		t.Fatalf("expected the literal at priority %d, got %q at %d", DefaultPriority, msg.Body, msg.Priority)
+	}
+}
+
+func TestMemoryClient_CompetingConsumers(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-competing:7222")
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	counts := make(map[string]int)
+
+	wg.Add(10)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		sub, err := c.Subscribe("queue.work", "queue", func(msg Message) {
+			lock.Lock()
+			counts[name]++
+			lock.Unlock()
+			wg.Done()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+	}
+
+	for i := 0; i < 10; i++ {
+		c.Send("queue.work", "queue", "work", 0, "non_persistent", 0)
+	}
+
+	wg.Wait()
+
+	if counts["a"]+counts["b"] != 10 {
+		t.Fatalf("messages delivered more than once: %v", counts)
+	}
+}
+
+func TestMemoryClient_TopicFanOut(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-topic:7222")
+
+	received := make(chan string, 4)
+
+	for i := 0; i < 2; i++ {
+		sub, err := c.Subscribe("topic.news", "topic", func(msg Message) {
+			received <- msg.Body
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+	}
+
+	if err := c.Send("topic.news", "topic", "extra", 0, "non_persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case body := <-received:
+			if body != "extra" {
+				t.Fatalf("bad body %q", body)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber %d not delivered", i)
+		}
+	}
+}
+
+func TestMemoryClient_DeliveryDelay(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-delay:7222")
+
+	c.Send("queue.delay", "queue", "later", 100, "non_persistent", 0)
+
+	_, timeout, _ := c.Receive("queue.delay", "queue", 20)
+	if !timeout {
+		t.Fatalf("delayed message delivered early")
+	}
+
+	body, timeout, _ := c.Receive("queue.delay", "queue", 1000)
+	if timeout || body != "later" {
+		t.Fatalf("delayed message not delivered")
+	}
+}
+
+func TestMemoryClient_Expiration(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-expiration:7222")
+
+	c.Send("queue.expire", "queue", "stale", 0, "non_persistent", 10)
+
+	time.Sleep(30 * time.Millisecond)
+
+	_, timeout, _ := c.Receive("queue.expire", "queue", 10)
+	if !timeout {
+		t.Fatalf("expired message delivered")
+	}
+}
+
+func TestMemoryClient_RequestReply(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-request:7222")
+
+	sub, err := c.Subscribe("queue.service", "queue", func(req Message) {
+		reply := NewMessage("re: " + req.Body)
+		reply.CorrelationID = req.CorrelationID
+		c.SendMessage(req.ReplyTo, req.ReplyToType, reply, 0, "non_persistent", 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	reply, err := c.SendReceive("queue.service", "queue", "ping", "non_persistent", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != "re: ping" {
+		t.Fatalf("bad reply %q", reply)
+	}
+}
+
+func TestMemoryClient_RequestReplyMessageID(t *testing.T) {
+
+	var mu sync.Mutex
+	var logs []string
+	logger := loggerFunc(func(format string, v ...interface{}) {
+		mu.Lock()
+		logs = append(logs, fmt.Sprintf(format, v...))
+		mu.Unlock()
+	})
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl("tcp://memory-request-id:7222").SetLogger(logger))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	// a JMS responder that sets the request's message ID as the correlation ID
+	sub, err := c.Subscribe("queue.service.id", "queue", func(req Message) {
+		reply := NewMessage("re: " + req.Body)
+		reply.CorrelationID = req.MessageID
+		c.SendMessage(req.ReplyTo, req.ReplyToType, reply, 0, "non_persistent", 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	reply, err := c.SendReceive("queue.service.id", "queue", "ping", "non_persistent", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply != "re: ping" {
+		t.Fatalf("bad reply %q", reply)
+	}
+
+	// a responder that sets neither ID
+	sub2, err := c.Subscribe("queue.service.none", "queue", func(req Message) {
+		c.SendMessage(req.ReplyTo, req.ReplyToType, NewMessage("re: "+req.Body), 0, "non_persistent", 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.SendReceiveContext(ctx, "queue.service.none", "queue", "ping", "non_persistent", 0); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	dropped := false
+	for _, l := range logs {
+		dropped = dropped || strings.Contains(l, "dropped reply")
+	}
+	if !dropped {
+		t.Fatalf("dropped reply not logged: %v", logs)
+	}
+}
+
+func TestMemoryClient_ReceiveContext(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-context:7222")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, _, err := c.ReceiveContext(ctx, "queue.empty", "queue", 0)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestMemoryClient_NotConnected(t *testing.T) {
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl("tcp://memory-not-connected:7222"))
+
+	if err := c.Send("queue.sample", "queue", "hello", 0, "non_persistent", 0); err == nil {
+		t.Fatalf("send succeeded without connect")
+	}
+}
+
+func TestMemoryClient_Bytes(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-bytes:7222")
+
+	if err := c.SendMessage("queue.mixed", "queue", NewBytesMessage([]byte{0, 1, 2, 255}), 0, "non_persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Send("queue.mixed", "queue", "text", 0, "non_persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _, err := c.ReceiveMessage("queue.mixed", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.BodyType != BodyTypeBytes || string(msg.Bytes) != "\x00\x01\x02\xff" {
+		t.Fatalf("bad bytes message %+v", msg)
+	}
+
+	msg, _, err = c.ReceiveMessage("queue.mixed", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.BodyType != BodyTypeText || msg.Body != "text" {
+		t.Fatalf("bad text message %+v", msg)
+	}
+
+	c.SendMessage("queue.mixed", "queue", NewBytesMessage([]byte("raw")), 0, "non_persistent", 0)
+
+	if _, _, err := c.Receive("queue.mixed", "queue", 1000); err == nil {
+		t.Fatalf("string receive accepted a bytes message")
+	}
+}
+
+func TestMemoryClient_Map(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-map:7222")
+
+	nested := map[string]interface{}{"flag": true}
+	fields := map[string]interface{}{
+		"count":  1,
+		"raw":    []byte("raw"),
+		"nested": nested,
+	}
+
+	if err := c.SendMessage("queue.map", "queue", NewMapMessage(fields), 0, "non_persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sent message is copied
+	nested["flag"] = false
+
+	msg, _, err := c.ReceiveMessage("queue.map", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, _ := msg.Map["nested"].(map[string]interface{})
+	if msg.BodyType != BodyTypeMap || msg.Map["count"] != int64(1) || received["flag"] != true {
+		t.Fatalf("bad map message %+v", msg)
+	}
+
+	bad := NewMapMessage(map[string]interface{}{"bad": struct{}{}})
+	if err := c.SendMessage("queue.map", "queue", bad, 0, "non_persistent", 0); err == nil {
+		t.Fatalf("unsupported map field accepted")
+	}
+}
+
+func TestMemoryClient_Stream(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-stream:7222")
+
+	msg := NewStreamMessage(true, 2, "three", []interface{}{int8(4)})
+
+	if err := c.SendMessage("queue.stream", "queue", msg, 0, "non_persistent", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	received, _, err := c.ReceiveMessage("queue.stream", "queue", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.BodyType != BodyTypeStream || len(received.Stream) != 4 || received.Stream[1] != int64(2) {
+		t.Fatalf("bad stream message %+v", received)
+	}
+
+	nested, err := NewStreamReader(Message{Stream: received.Stream[3:]}).ReadStream()
+	if err != nil || nested[0] != int8(4) {
+		t.Fatalf("bad nested stream %v %v", nested, err)
+	}
+}
+
+func TestMemoryClient_Selector(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-selector:7222")
+
+	for _, color := range []string{"red", "blue"} {
+		msg := NewMessage(color)
+		msg.SetProperty("color", color)
+		c.SendMessage("queue.colors", "queue", msg, 0, "non_persistent", 0)
+	}
+
+	body, timeout, err := c.Receive("queue.colors", "queue", 1000, WithSelector("color = 'blue'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || body != "blue" {
+		t.Fatalf("expected the blue message, got %q", body)
+	}
+
+	// the red message stays on the queue for other consumers
+	body, _, _ = c.Receive("queue.colors", "queue", 1000)
+	if body != "red" {
+		t.Fatalf("expected the red message, got %q", body)
+	}
+
+	if _, _, err := c.Receive("queue.colors", "queue", 10, WithSelector("color =")); !errors.Is(err, ErrInvalidSelector) {
+		t.Fatalf("expected ErrInvalidSelector, got %v", err)
+	}
+
+	received := make(chan string, 2)
+	sub, err := c.Subscribe("topic.colors", "topic", func(msg Message) {
+		received <- msg.Body
+	}, WithSelector("color = 'red'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	for _, color := range []string{"blue", "red"} {
+		msg := NewMessage(color)
+		msg.SetProperty("color", color)
+		c.SendMessage("topic.colors", "topic", msg, 0, "non_persistent", 0)
+	}
+
+	select {
+	case body := <-received:
+		if body != "red" {
+			t.Fatalf("subscriber received %q", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("subscriber not delivered")
+	}
+}
+
+func TestMemoryClient_DurableSubscription(t *testing.T) {
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl("tcp://memory-durable:7222").SetClientID("client-1"))
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	// create the subscription, then publish while no consumer is open
+	_, timeout, err := c.Receive("topic.orders", "topic", 10, WithDurable("orders"))
+	if err != nil || !timeout {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	c.Send("topic.orders", "topic", "kept", 0, "persistent", 0)
+
+	body, timeout, err := c.Receive("topic.orders", "topic", 1000, WithDurable("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout || body != "kept" {
+		t.Fatalf("durable subscription lost the message")
+	}
+
+	if err := c.Unsubscribe("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Unsubscribe("orders"); err == nil {
+		t.Fatalf("unsubscribed twice")
+	}
+
+	if _, _, err := c.Receive("queue.orders", "queue", 10, WithDurable("orders")); err == nil {
+		t.Fatalf("durable subscription created on a queue")
+	}
+}
+
+func TestMemoryClient_SharedSubscription(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-shared-subscription:7222")
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	count := 0
+
+	wg.Add(10)
+	for i := 0; i < 2; i++ {
+		sub, err := c.Subscribe("topic.work", "topic", func(msg Message) {
+			lock.Lock()
+			count++
+			lock.Unlock()
+			wg.Done()
+		}, WithShared("workers"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+	}
+
+	for i := 0; i < 10; i++ {
+		c.Send("topic.work", "topic", "work", 0, "non_persistent", 0)
+	}
+
+	wg.Wait()
+
+	if count != 10 {
+		t.Fatalf("shared subscription delivered %d messages", count)
+	}
+}
+
+func TestMemoryClient_ClientAcknowledge(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-ack:7222")
+
+	c.Send("queue.ack", "queue", "first", 0, "persistent", 0)
+
+	msg, _, err := c.ReceiveMessage("queue.ack", "queue", 1000, WithAcknowledgeMode(TIBEMS_CLIENT_ACKNOWLEDGE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the message is held until it is acknowledged or recovered
+	if _, timeout, _ := c.Receive("queue.ack", "queue", 10); !timeout {
+		t.Fatalf("unacknowledged message delivered twice")
+	}
+
+	if err := msg.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _, err = c.ReceiveMessage("queue.ack", "queue", 1000, WithAcknowledgeMode(TIBEMS_CLIENT_ACKNOWLEDGE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Body != "first" || !msg.Redelivered {
+		t.Fatalf("recovered message not redelivered %+v", msg)
+	}
+
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := msg.Ack(); err == nil {
+		t.Fatalf("message acknowledged twice")
+	}
+
+	if _, timeout, _ := c.Receive("queue.ack", "queue", 10); !timeout {
+		t.Fatalf("acknowledged message redelivered")
+	}
+
+	// messages left unacknowledged are redelivered after a disconnect
+	other := newMemoryTestClient(t, "tcp://memory-ack:7222")
+
+	c.Send("queue.ack", "queue", "second", 0, "persistent", 0)
+	if _, _, err := c.ReceiveMessage("queue.ack", "queue", 1000, WithAcknowledgeMode(TIBEMS_EXPLICIT_CLIENT_ACKNOWLEDGE)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Disconnect()
+
+	body, timeout, _ := other.Receive("queue.ack", "queue", 1000)
+	if timeout || body != "second" {
+		t.Fatalf("unacknowledged message lost on disconnect")
+	}
+}
+
+func TestMemoryClient_ExplicitAcknowledge(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-explicit-ack:7222")
+
+	received := make(chan Message, 2)
+	sub, err := c.Subscribe("queue.explicit", "queue", func(msg Message) {
+		received <- msg
+	}, WithAcknowledgeMode(TIBEMS_EXPLICIT_CLIENT_ACKNOWLEDGE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Send("queue.explicit", "queue", "acked", 0, "persistent", 0)
+	c.Send("queue.explicit", "queue", "pending", 0, "persistent", 0)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			if msg.Body == "acked" {
+				msg.Ack()
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message not delivered")
+		}
+	}
+
+	sub.Close()
+
+	body, timeout, _ := c.Receive("queue.explicit", "queue", 1000)
+	if timeout || body != "pending" {
+		t.Fatalf("expected the unacknowledged message, got %q", body)
+	}
+}
+
+func TestMemoryClient_Transaction(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-tx:7222")
+
+	c.Send("queue.in", "queue", "order", 0, "persistent", 0)
+
+	tx, err := c.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forward := func() {
+		body, timeout, err := tx.Receive("queue.in", "queue", 1000)
+		if err != nil || timeout {
+			t.Fatalf("receive in transaction failed: %v", err)
+		}
+		if err := tx.Send("queue.out", "queue", body, 0, "persistent", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forward()
+
+	if _, timeout, _ := c.Receive("queue.out", "queue", 10); !timeout {
+		t.Fatalf("uncommitted send delivered")
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, timeout, _ := c.Receive("queue.out", "queue", 10); !timeout {
+		t.Fatalf("rolled back send delivered")
+	}
+
+	forward()
+
+	// a receive without a timeout would hold up Commit
+	if _, _, err := tx.Receive("queue.in", "queue", 0); !errors.Is(err, errTxTimeout) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, timeout, _ := c.ReceiveMessage("queue.out", "queue", 1000)
+	if timeout || msg.Body != "order" {
+		t.Fatalf("committed send not delivered")
+	}
+
+	if _, timeout, _ := c.Receive("queue.in", "queue", 10); !timeout {
+		t.Fatalf("committed receive redelivered")
+	}
+
+	// closing rolls back the open transaction
+	c.Send("queue.in", "queue", "second", 0, "persistent", 0)
+	forward()
+	tx.Close()
+
+	msg, timeout, _ = c.ReceiveMessage("queue.in", "queue", 1000)
+	if timeout || msg.Body != "second" || !msg.Redelivered {
+		t.Fatalf("receive not rolled back on close")
+	}
+
+	if err := tx.Commit(); !errors.Is(err, ErrIllegalState) {
+		t.Fatalf("expected ErrIllegalState, got %v", err)
+	}
+}
+
+func TestMemoryClient_Request(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-request-reply:7222")
+
+	// the responder answers in reverse order so replies must be matched by correlation ID
+	requests := make(chan Message, 10)
+	sub, err := c.Subscribe("queue.service", "queue", func(req Message) {
+		requests <- req
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	go func() {
+		var pending []Message
+		for req := range requests {
+			pending = append(pending, req)
+			if len(pending) < 3 {
+				continue
+			}
+			for i := len(pending) - 1; i >= 0; i-- {
+				reply := NewMessage("re: " + pending[i].Body)
+				reply.CorrelationID = pending[i].CorrelationID
+				c.SendMessage(pending[i].ReplyTo, pending[i].ReplyToType, reply, 0, "non_persistent", 0)
+			}
+			pending = nil
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, body := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			reply, timeout, err := c.Request("queue.service", "queue", NewMessage(body), 1000, "non_persistent", 0)
+			if err != nil || timeout {
+				t.Errorf("request %q failed: %v %v", body, timeout, err)
+				return
+			}
+			if reply.Body != "re: "+body {
+				t.Errorf("request %q got reply %q", body, reply.Body)
+			}
+		}(body)
+	}
+	wg.Wait()
+	close(requests)
+
+	_, timeout, err := c.Request("queue.nobody", "queue", NewMessage("hello"), 20, "non_persistent", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !timeout {
+		t.Fatalf("expected timeout")
+	}
+}
+
+func TestMemoryClient_Serve(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-serve:7222")
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+
+	srv, err := c.Serve("queue.rpc", func(req Message) (Message, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		if req.Body == "fail" {
+			return Message{}, errors.New("bad request")
+		}
+		return NewMessage("re: " + req.Body), nil
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for _, body := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			reply, timeout, err := c.Request("queue.rpc", "queue", NewMessage(body), 2000, "non_persistent", 0)
+			if err != nil || timeout {
+				t.Errorf("request %q failed: %v %v", body, timeout, err)
+				return
+			}
+			if reply.Body != "re: "+body || reply.Properties[ReplyStatusProperty] != ReplyStatusOK {
+				t.Errorf("request %q got reply %q %v", body, reply.Body, reply.Properties)
+			}
+		}(body)
+	}
+	wg.Wait()
+
+	if peak != 2 {
+		t.Fatalf("expected 2 concurrent handlers, got %d", peak)
+	}
+
+	reply, _, err := c.Request("queue.rpc", "queue", NewMessage("fail"), 2000, "non_persistent", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Properties[ReplyStatusProperty] != ReplyStatusError || reply.Properties[ReplyErrorProperty] != "bad request" {
+		t.Fatalf("bad error reply %v", reply.Properties)
+	}
+}
+
+func TestMemoryClient_Browse(t *testing.T) {
+
+	c := newMemoryTestClient(t, "tcp://memory-browse:7222")
+
+	for _, region := range []string{"EU", "US", "EU"} {
+		m := NewMessage("order " + region)
+		m.SetProperty("region", region)
+		if err := c.SendMessage("queue.browse", "queue", m, 0, "persistent", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	browse := func(opts ...ConsumerOption) []string {
+		b, err := c.Browse("queue.browse", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+
+		var bodies []string
+		for {
+			m, ok, err := b.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return bodies
+			}
+			bodies = append(bodies, m.Body)
+		}
+	}
+
+	if bodies := browse(); len(bodies) != 3 {
+		t.Fatalf("expected 3 messages, got %v", bodies)
+	}
+
+	if bodies := browse(WithSelector("region = 'EU'")); len(bodies) != 2 {
+		t.Fatalf("expected 2 messages, got %v", bodies)
+	}
+
+	// browsed messages are copies of the queued ones
+	b, err := c.Browse("queue.browse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	browsed, _, err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	browsed.SetProperty("region", "APAC")
+
+	// browsing leaves the messages on the queue
+	m, timeout, err := c.ReceiveMessage("queue.browse", "queue", 100)
+	if err != nil || timeout {
+		t.Fatalf("browsed message was consumed")
+	}
+
+	if m.Properties["region"] != "EU" {
+		t.Fatalf("browsed message shares properties with the queued one: %v", m.Properties["region"])
+	}
+}
+
+func TestMemoryClient_LookupDestination(t *testing.T) {
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl("tcp://memory-lookup:7222").SetConnectionFactoryName("FTQueueConnectionFactory")).(*MemoryClient)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	c.BindDestination("orders", "queue.orders.v2", "queue")
+
+	dest, destType, err := c.LookupDestination("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dest != "queue.orders.v2" || destType != "queue" {
+		t.Fatalf("bad destination %s %s", dest, destType)
+	}
+
+	if _, _, err := c.LookupDestination("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestMemoryClient_Metadata(t *testing.T) {
+
+	c := NewMemoryClient(NewClientOptions().SetServerUrl("tcp://memory-meta-a:7222,tcp://memory-meta-b:7222").SetClientID("billing"))
+
+	if _, err := c.Metadata(); !errors.Is(err, ErrInvalidConnection) {
+		t.Fatalf("expected not connected, got %v", err)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	m, err := c.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ClientID != "billing" || m.ActiveURL != "tcp://memory-meta-a:7222" || !m.FaultTolerant || len(m.ServerURLs) != 2 {
+		t.Fatalf("bad metadata %+v", m)
+	}
+}
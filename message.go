@@ -0,0 +1,196 @@
+package ems
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultPriority is the JMS priority given to messages created by NewMessage,
+// and to messages sent with a Priority of 0.
+const DefaultPriority = 4
+
+// Message body types, as reported in Message.BodyType.
+const (
+	BodyTypeMessage = "MESSAGE"
+	BodyTypeText    = "TEXT"
+	BodyTypeBytes   = "BYTES"
+	BodyTypeMap     = "MAP"
+	BodyTypeStream  = "STREAM"
+	BodyTypeObject  = "OBJECT"
+)
+
+// Message is an EMS message together with its JMS header fields and properties.
+//
+// BodyType selects which field holds the body: Body for text messages,
+// Bytes for bytes messages, Map for map messages and Stream for stream
+// messages. Messages without a BodyType are sent as text.
+//
+// Map and stream fields may hold the property types below as well as int,
+// []byte, nested map[string]interface{} and nested []interface{} values;
+// int is sent as a 64 bit integer and nested values use the TIBCO nested
+// message extension. Received fields use the same types, with array fields
+// converted to slices.
+//
+// Properties may hold values of type bool, int8, int16, int32, int64,
+// float32, float64 and string; any other type is rejected by SendMessage.
+type Message struct {
+	BodyType string
+	Body     string
+	Bytes    []byte
+	Map      map[string]interface{}
+	Stream   []interface{}
+
+	MessageID     string
+	CorrelationID string
+	ReplyTo       string // destination name
+	ReplyToType   string // "queue" or "topic"
+	Type          string
+	Priority      int // 1 to 9; 0 sends the message at DefaultPriority
+	Timestamp     time.Time
+	Redelivered   bool
+
+	Properties map[string]interface{}
+
+	acker acker // set on messages received in a client acknowledge mode
+}
+
+// acker acknowledges or recovers a received message.
+type acker interface {
+	ack() error
+	recover() error
+}
+
+// NewMessage returns a message with the given body and the default priority.
+func NewMessage(body string) Message {
+	return Message{
+		BodyType:   BodyTypeText,
+		Body:       body,
+		Priority:   DefaultPriority,
+		Properties: make(map[string]interface{}),
+	}
+}
+
+// NewBytesMessage returns a bytes message with the given body and the default priority.
+func NewBytesMessage(body []byte) Message {
+	m := NewMessage("")
+	m.BodyType = BodyTypeBytes
+	m.Bytes = body
+	return m
+}
+
+// NewMapMessage returns a map message with the given fields and the default priority.
+func NewMapMessage(fields map[string]interface{}) Message {
+	m := NewMessage("")
+	m.BodyType = BodyTypeMap
+	m.Map = fields
+	return m
+}
+
+// NewStreamMessage returns a stream message with the given values and the default priority.
+func NewStreamMessage(values ...interface{}) Message {
+	m := NewMessage("")
+	m.BodyType = BodyTypeStream
+	m.Stream = values
+	return m
+}
+
+// Ack acknowledges a message received in a client acknowledge mode. In
+// TIBEMS_CLIENT_ACKNOWLEDGE mode it acknowledges every message delivered by
+// the consumer's session so far; in the explicit modes only this message.
+// Ack does nothing for messages received in the other modes.
+func (m Message) Ack() error {
+	if m.acker == nil {
+		return nil
+	}
+	return m.acker.ack()
+}
+
+// Recover redelivers the unacknowledged messages of the session that
+// delivered a message received in a client acknowledge mode, with
+// Redelivered set. Recover does nothing for messages received in the
+// other modes.
+func (m Message) Recover() error {
+	if m.acker == nil {
+		return nil
+	}
+	return m.acker.recover()
+}
+
+// SetProperty sets a user property on the message.
+func (m *Message) SetProperty(name string, value interface{}) {
+	if m.Properties == nil {
+		m.Properties = make(map[string]interface{})
+	}
+	m.Properties[name] = value
+}
+
+// textBody returns the body of a text message for the string based functions,
+// which do not handle other body types.
+func textBody(m Message) (string, error) {
+	if m.BodyType != BodyTypeText {
+		return "", errors.New("Unable to process message type " + m.BodyType)
+	}
+	return m.Body, nil
+}
+
+// copyMap returns a deep copy of a map message body, converting int fields to
+// int64 as they would be received from the server. It fails on field types
+// that cannot be stored in a map message.
+func copyMap(fields map[string]interface{}) (map[string]interface{}, error) {
+
+	if fields == nil {
+		return nil, nil
+	}
+
+	c := make(map[string]interface{}, len(fields))
+
+	for name, value := range fields {
+		v, err := copyField(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v for map field %s", err, name)
+		}
+		c[name] = v
+	}
+
+	return c, nil
+}
+
+// copyStream returns a deep copy of a stream message body, converting fields
+// as copyMap does.
+func copyStream(values []interface{}) ([]interface{}, error) {
+
+	if values == nil {
+		return nil, nil
+	}
+
+	c := make([]interface{}, len(values))
+
+	for i, value := range values {
+		v, err := copyField(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v for stream field %d", err, i)
+		}
+		c[i] = v
+	}
+
+	return c, nil
+}
+
+func copyField(value interface{}) (interface{}, error) {
+
+	switch v := value.(type) {
+	case bool, int8, int16, int32, int64, float32, float64, string:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case []byte:
+		return append([]byte{}, v...), nil
+	case map[string]interface{}:
+		return copyMap(v)
+	case []interface{}:
+		return copyStream(v)
+	}
+
+	return nil, fmt.Errorf("unsupported type %T", value)
+}
@@ -0,0 +1,74 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+*/
+import "C"
+import "strings"
+
+// Metadata returns the client ID, provider and version details and the URL
+// of the server the connection currently uses, which changes after a
+// fault-tolerant switch.
+func (c *Client) Metadata() (ConnectionMetadata, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.conn == nil {
+		return ConnectionMetadata{}, newError(TIBEMS_INVALID_CONNECTION, "not connected", "")
+	}
+
+	m := ConnectionMetadata{
+		ServerURLs: strings.Split(c.options.serverURLString(), ","),
+	}
+	m.FaultTolerant = len(m.ServerURLs) > 1
+
+	var clientID *C.char
+	status := C.tibemsConnection_GetClientId(c.conn, &clientID)
+	if status != TIBEMS_OK {
+		return ConnectionMetadata{}, c.newError(status)
+	}
+	if clientID != nil {
+		m.ClientID = C.GoString(clientID)
+	}
+
+	var activeURL *C.char
+	status = C.tibemsConnection_GetActiveURL(c.conn, &activeURL)
+	if status != TIBEMS_OK {
+		return ConnectionMetadata{}, c.newError(status)
+	}
+	if activeURL != nil {
+		m.ActiveURL = C.GoString(activeURL)
+	}
+
+	var metaData C.tibemsConnectionMetaData
+	status = C.tibemsConnection_GetMetaData(c.conn, &metaData)
+	if status != TIBEMS_OK {
+		return ConnectionMetadata{}, c.newError(status)
+	}
+
+	var providerName, emsVersion, providerVersion *C.char
+
+	status = C.tibemsConnectionMetaData_GetEMSProviderName(metaData, &providerName)
+	if status != TIBEMS_OK {
+		return ConnectionMetadata{}, c.newError(status)
+	}
+
+	status = C.tibemsConnectionMetaData_GetEMSVersion(metaData, &emsVersion)
+	if status != TIBEMS_OK {
+		return ConnectionMetadata{}, c.newError(status)
+	}
+
+	status = C.tibemsConnectionMetaData_GetProviderVersion(metaData, &providerVersion)
+	if status != TIBEMS_OK {
+		return ConnectionMetadata{}, c.newError(status)
+	}
+
+	m.ProviderName = C.GoString(providerName)
+	m.EMSVersion = C.GoString(emsVersion)
+	m.ProviderVersion = C.GoString(providerVersion)
+
+	return m, nil
+}
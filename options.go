@@ -1,11 +1,56 @@
 package ems
 
-import "net/url"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ExceptionListener is called when the EMS connection reports an
+// asynchronous failure or fault-tolerant switch. err is an *Error whose
+// status is typically TIBEMS_SERVER_DISCONNECTED, TIBEMS_SERVER_RECONNECTING
+// or TIBEMS_SERVER_RECONNECTED.
+type ExceptionListener func(err error)
+
+// Logger receives the client's connection log messages. *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
 
 type ClientOptions struct {
-	serverUrl url.URL
-	username  string
-	password  string
+	serverUrl               url.URL
+	serverUrls              []url.URL
+	urlErr                  error // first server URL that failed to parse
+	username                string
+	password                string
+	credentials             CredentialsProvider
+	clientID                string
+	connectionFactory       string // name looked up through the server's lookup context
+	connectAttemptCount     int
+	connectAttemptDelay     int
+	connectAttemptTimeout   int
+	reconnectAttemptCount   int
+	reconnectAttemptDelay   int
+	reconnectAttemptTimeout int
+	exceptionListener       ExceptionListener
+	eventListener           ConnectionEventListener
+	logger                  Logger
+	ssl                     sslOptions
+}
+
+// sslOptions holds the SSL parameters of the connection factory.
+type sslOptions struct {
+	trustedCerts     []string
+	identity         string
+	privateKey       string
+	privateKeyPass   string
+	ciphers          string
+	noVerifyHost     bool
+	noVerifyHostName bool
+	expectedHostName string
 }
 
 func NewClientOptions() *ClientOptions {
@@ -17,11 +62,26 @@ func NewClientOptions() *ClientOptions {
 	return o
 }
 
+// SetServerUrl sets the server URL. A comma separated list of URLs, such as
+// "tcp://a:7222,tcp://b:7222", configures a fault-tolerant server pair.
 func (o *ClientOptions) SetServerUrl(p string) *ClientOptions {
 
-	url, err := url.Parse(p)
-	if err == nil {
-		o.serverUrl = *url
+	o.serverUrls = nil
+	o.urlErr = nil
+	for _, s := range strings.Split(p, ",") {
+		url, err := url.Parse(strings.TrimSpace(s))
+		if err != nil {
+			// reported by Validate
+			if o.urlErr == nil {
+				o.urlErr = fmt.Errorf("invalid server URL %q: %v", strings.TrimSpace(s), err)
+			}
+			continue
+		}
+		o.serverUrls = append(o.serverUrls, *url)
+	}
+
+	if len(o.serverUrls) > 0 {
+		o.serverUrl = o.serverUrls[0]
 	}
 	return o
 }
@@ -36,10 +96,149 @@ func (o *ClientOptions) SetPassword(p string) *ClientOptions {
 	return o
 }
 
+// SetCredentialsProvider sets the provider asked for the username and
+// password on every Connect, in place of SetUsername and SetPassword.
+func (o *ClientOptions) SetCredentialsProvider(p CredentialsProvider) *ClientOptions {
+	o.credentials = p
+	return o
+}
+
+// SetClientID sets the client ID of the connection, which identifies the
+// client's durable subscriptions.
+func (o *ClientOptions) SetClientID(p string) *ClientOptions {
+	o.clientID = p
+	return o
+}
+
+// SetConnectionFactoryName makes Connect look up the named connection
+// factory through the lookup context of the server at the server URL, rather
+// than building one from the options. The factory brings the URLs, fault
+// tolerance and SSL settings configured by the EMS administrators; the
+// client ID, connect and reconnect settings of the options still apply, and
+// the SSL settings of the options only secure the lookup.
+func (o *ClientOptions) SetConnectionFactoryName(p string) *ClientOptions {
+	o.connectionFactory = p
+	return o
+}
+
+// SetConnectAttemptCount sets how many times the client tries to connect
+// to each server before Connect fails.
+func (o *ClientOptions) SetConnectAttemptCount(p int) *ClientOptions {
+	o.connectAttemptCount = p
+	return o
+}
+
+// SetConnectAttemptDelay sets the delay in milliseconds between connect attempts.
+func (o *ClientOptions) SetConnectAttemptDelay(p int) *ClientOptions {
+	o.connectAttemptDelay = p
+	return o
+}
+
+// SetConnectAttemptTimeout sets the timeout in milliseconds of each connect
+// attempt. A ConnectContext deadline still bounds the whole connect.
+func (o *ClientOptions) SetConnectAttemptTimeout(p int) *ClientOptions {
+	o.connectAttemptTimeout = p
+	return o
+}
+
+// SetReconnectAttemptCount sets how many times the client tries to reconnect
+// after losing its connection to the server.
+func (o *ClientOptions) SetReconnectAttemptCount(p int) *ClientOptions {
+	o.reconnectAttemptCount = p
+	return o
+}
+
+// SetReconnectAttemptDelay sets the delay in milliseconds between reconnect attempts.
+func (o *ClientOptions) SetReconnectAttemptDelay(p int) *ClientOptions {
+	o.reconnectAttemptDelay = p
+	return o
+}
+
+// SetReconnectAttemptTimeout sets the timeout in milliseconds of each reconnect attempt.
+func (o *ClientOptions) SetReconnectAttemptTimeout(p int) *ClientOptions {
+	o.reconnectAttemptTimeout = p
+	return o
+}
+
+// SetExceptionListener sets the function notified of connection failures
+// and reconnect events.
+func (o *ClientOptions) SetExceptionListener(p ExceptionListener) *ClientOptions {
+	o.exceptionListener = p
+	return o
+}
+
+// SetSSLTrustedCertificates sets the files of the CA certificates trusted to
+// sign the server certificate on ssl:// connections.
+func (o *ClientOptions) SetSSLTrustedCertificates(files ...string) *ClientOptions {
+	o.ssl.trustedCerts = files
+	return o
+}
+
+// SetSSLIdentity sets the client certificate file and the file of its private
+// key. keyFile may be empty when the identity file also holds the key, as a
+// PKCS#12 file does.
+func (o *ClientOptions) SetSSLIdentity(certFile string, keyFile string) *ClientOptions {
+	o.ssl.identity = certFile
+	o.ssl.privateKey = keyFile
+	return o
+}
+
+// SetSSLPrivateKeyPassword sets the password decrypting the client private key.
+func (o *ClientOptions) SetSSLPrivateKeyPassword(p string) *ClientOptions {
+	o.ssl.privateKeyPass = p
+	return o
+}
+
+// SetSSLCiphers sets the cipher suites the client accepts, in OpenSSL cipher
+// list format.
+func (o *ClientOptions) SetSSLCiphers(p string) *ClientOptions {
+	o.ssl.ciphers = p
+	return o
+}
+
+// SetSSLVerifyHost sets whether the server certificate is verified against
+// the trusted certificates. It is on by default.
+func (o *ClientOptions) SetSSLVerifyHost(p bool) *ClientOptions {
+	o.ssl.noVerifyHost = !p
+	return o
+}
+
+// SetSSLVerifyHostName sets whether the name in the server certificate must
+// match the server host name, or the name set by SetSSLExpectedHostName.
+// It is on by default.
+func (o *ClientOptions) SetSSLVerifyHostName(p bool) *ClientOptions {
+	o.ssl.noVerifyHostName = !p
+	return o
+}
+
+// SetSSLExpectedHostName sets the name expected in the server certificate
+// when it differs from the host name in the server URL.
+func (o *ClientOptions) SetSSLExpectedHostName(p string) *ClientOptions {
+	o.ssl.expectedHostName = p
+	return o
+}
+
+// SetConnectionEventListener sets the function called with a typed event for
+// each disconnect, reconnect and fatal connection failure.
+func (o *ClientOptions) SetConnectionEventListener(p ConnectionEventListener) *ClientOptions {
+	o.eventListener = p
+	return o
+}
+
+// SetLogger sets the logger for connection events. By default nothing is logged.
+func (o *ClientOptions) SetLogger(p Logger) *ClientOptions {
+	o.logger = p
+	return o
+}
+
 func (o *ClientOptions) GetServerUrl() url.URL {
 	return o.serverUrl
 }
 
+func (o *ClientOptions) GetServerUrls() []url.URL {
+	return o.serverUrls
+}
+
 func (o *ClientOptions) GetUsername() string {
 	return o.username
 }
@@ -47,3 +246,424 @@ func (o *ClientOptions) GetUsername() string {
 func (o *ClientOptions) GetPassword() string {
 	return o.password
 }
+
+func (o *ClientOptions) GetCredentialsProvider() CredentialsProvider {
+	return o.credentials
+}
+
+// getCredentials returns the username and password to connect with.
+func (o *ClientOptions) getCredentials(ctx context.Context) (string, string, error) {
+
+	if o.credentials == nil {
+		return o.username, o.password, nil
+	}
+
+	username, password, err := o.credentials.Credentials(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get credentials: %w", err)
+	}
+
+	return username, password, nil
+}
+
+func (o *ClientOptions) GetClientID() string {
+	return o.clientID
+}
+
+func (o *ClientOptions) GetConnectionFactoryName() string {
+	return o.connectionFactory
+}
+
+func (o *ClientOptions) GetConnectAttemptCount() int {
+	return o.connectAttemptCount
+}
+
+func (o *ClientOptions) GetConnectAttemptDelay() int {
+	return o.connectAttemptDelay
+}
+
+func (o *ClientOptions) GetConnectAttemptTimeout() int {
+	return o.connectAttemptTimeout
+}
+
+func (o *ClientOptions) GetReconnectAttemptCount() int {
+	return o.reconnectAttemptCount
+}
+
+func (o *ClientOptions) GetReconnectAttemptDelay() int {
+	return o.reconnectAttemptDelay
+}
+
+func (o *ClientOptions) GetReconnectAttemptTimeout() int {
+	return o.reconnectAttemptTimeout
+}
+
+func (o *ClientOptions) GetSSLTrustedCertificates() []string {
+	return o.ssl.trustedCerts
+}
+
+func (o *ClientOptions) GetSSLCiphers() string {
+	return o.ssl.ciphers
+}
+
+func (o *ClientOptions) GetSSLVerifyHost() bool {
+	return !o.ssl.noVerifyHost
+}
+
+func (o *ClientOptions) GetSSLVerifyHostName() bool {
+	return !o.ssl.noVerifyHostName
+}
+
+func (o *ClientOptions) GetSSLExpectedHostName() string {
+	return o.ssl.expectedHostName
+}
+
+// usesSSL reports whether the connection factory needs SSL parameters: a
+// server URL has the ssl scheme or an SSL option is set.
+func (o *ClientOptions) usesSSL() bool {
+
+	for _, u := range o.serverUrls {
+		if strings.EqualFold(u.Scheme, "ssl") {
+			return true
+		}
+	}
+
+	if strings.EqualFold(o.serverUrl.Scheme, "ssl") {
+		return true
+	}
+
+	s := o.ssl
+	return len(s.trustedCerts) > 0 || s.identity != "" || s.privateKey != "" || s.privateKeyPass != "" ||
+		s.ciphers != "" || s.noVerifyHost || s.noVerifyHostName || s.expectedHostName != ""
+}
+
+// lookupURLString returns the server URLs in the tibjmsnaming form expected
+// by tibemsLookupContext_Create.
+func (o *ClientOptions) lookupURLString() string {
+
+	urls := o.serverUrls
+	if len(urls) == 0 {
+		urls = []url.URL{o.serverUrl}
+	}
+
+	names := make([]string, len(urls))
+	for i, u := range urls {
+		u.Scheme = "tibjmsnaming"
+		names[i] = u.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// Validate checks the options before a client is created, and again on
+// Connect: the server URLs must parse, use the tcp or ssl scheme and name a
+// host, and settings that depend on each other must agree.
+func (o *ClientOptions) Validate() error {
+
+	if o.urlErr != nil {
+		return o.urlErr
+	}
+
+	urls := o.serverUrls
+	if len(urls) == 0 && o.serverUrl != (url.URL{}) {
+		urls = []url.URL{o.serverUrl}
+	}
+
+	if len(urls) == 0 {
+		return errors.New("server URL is not set")
+	}
+
+	ssl := 0
+	for _, u := range urls {
+		switch strings.ToLower(u.Scheme) {
+		case "tcp":
+		case "ssl":
+			ssl++
+		case "":
+			return fmt.Errorf("server URL %q has no scheme, expected tcp:// or ssl://", u.String())
+		default:
+			return fmt.Errorf("server URL %q has unsupported scheme %q, expected tcp or ssl", u.String(), u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return fmt.Errorf("server URL %q has no host", u.String())
+		}
+	}
+
+	if ssl > 0 && ssl < len(urls) {
+		return errors.New("server URLs mix the tcp and ssl schemes")
+	}
+
+	if ssl == 0 && o.usesSSL() {
+		return errors.New("SSL options are set but no server URL uses ssl://")
+	}
+
+	if o.ssl.privateKeyPass != "" && o.ssl.identity == "" {
+		return errors.New("SSL private key password is set without an SSL identity")
+	}
+
+	if o.credentials != nil && (o.username != "" || o.password != "") {
+		return errors.New("username or password is set together with a credentials provider")
+	}
+
+	if o.password != "" && o.username == "" {
+		return errors.New("password is set without a username")
+	}
+
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"connect attempt count", o.connectAttemptCount},
+		{"connect attempt delay", o.connectAttemptDelay},
+		{"connect attempt timeout", o.connectAttemptTimeout},
+		{"reconnect attempt count", o.reconnectAttemptCount},
+		{"reconnect attempt delay", o.reconnectAttemptDelay},
+		{"reconnect attempt timeout", o.reconnectAttemptTimeout},
+	} {
+		if v.value < 0 {
+			return fmt.Errorf("%s is negative: %d", v.name, v.value)
+		}
+	}
+
+	if o.reconnectAttemptCount == 0 && (o.reconnectAttemptDelay > 0 || o.reconnectAttemptTimeout > 0) {
+		return errors.New("reconnect attempt delay or timeout is set but reconnect attempt count is 0")
+	}
+
+	return nil
+}
+
+// logf logs a connection event if a logger is set.
+func (o *ClientOptions) logf(format string, v ...interface{}) {
+	if o.logger != nil {
+		o.logger.Printf(format, v...)
+	}
+}
+
+// serverURLString returns the server URLs in the form expected by
+// tibemsConnectionFactory_SetServerURL.
+func (o *ClientOptions) serverURLString() string {
+
+	if len(o.serverUrls) == 0 {
+		return o.serverUrl.String()
+	}
+
+	urls := make([]string, len(o.serverUrls))
+	for i, u := range o.serverUrls {
+		urls[i] = u.String()
+	}
+	return strings.Join(urls, ",")
+}
+
+// ClientOption configures the client created by NewClientWithOptions.
+type ClientOption func(*ClientOptions)
+
+// NewClientWithOptions applies opts to new ClientOptions and returns a client
+// for them, or the error from Validate.
+func NewClientWithOptions(opts ...ClientOption) (IClient, error) {
+
+	o, err := applyClientOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(o), nil
+}
+
+// applyClientOptions applies opts to new ClientOptions and validates them.
+func applyClientOptions(opts []ClientOption) (*ClientOptions, error) {
+
+	o := NewClientOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// WithServerURL sets the server URL, or a comma separated fault-tolerant pair.
+func WithServerURL(url string) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetServerUrl(url)
+	}
+}
+
+// WithCredentials sets the username and password.
+func WithCredentials(username string, password string) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetUsername(username).SetPassword(password)
+	}
+}
+
+// WithCredentialsProvider sets the provider of the username and password.
+func WithCredentialsProvider(p CredentialsProvider) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetCredentialsProvider(p)
+	}
+}
+
+// WithClientID sets the client ID of the connection.
+func WithClientID(id string) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetClientID(id)
+	}
+}
+
+// WithConnectionFactory looks up the named connection factory on the server
+// instead of building one from the options.
+func WithConnectionFactory(name string) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetConnectionFactoryName(name)
+	}
+}
+
+// WithConnectAttempts sets the connect attempt count, and the delay between
+// and timeout of the attempts in milliseconds.
+func WithConnectAttempts(count int, delay int, timeout int) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetConnectAttemptCount(count).SetConnectAttemptDelay(delay).SetConnectAttemptTimeout(timeout)
+	}
+}
+
+// WithReconnect sets the reconnect attempt count, and the delay between and
+// timeout of the attempts in milliseconds.
+func WithReconnect(count int, delay int, timeout int) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetReconnectAttemptCount(count).SetReconnectAttemptDelay(delay).SetReconnectAttemptTimeout(timeout)
+	}
+}
+
+// WithSSL sets the trusted CA certificate files and the client certificate,
+// private key and key password. Empty values are left unset.
+func WithSSL(trustedCerts []string, certFile string, keyFile string, keyPassword string) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetSSLTrustedCertificates(trustedCerts...).SetSSLIdentity(certFile, keyFile).SetSSLPrivateKeyPassword(keyPassword)
+	}
+}
+
+// WithExceptionListener sets the function notified of connection failures.
+func WithExceptionListener(l ExceptionListener) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetExceptionListener(l)
+	}
+}
+
+// WithConnectionEventListener sets the function notified of connection events.
+func WithConnectionEventListener(l ConnectionEventListener) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetConnectionEventListener(l)
+	}
+}
+
+// WithLogger sets the logger for connection events.
+func WithLogger(l Logger) ClientOption {
+	return func(o *ClientOptions) {
+		o.SetLogger(l)
+	}
+}
+
+// ConsumerOption configures the consumer created by Receive, ReceiveMessage
+// and Subscribe.
+type ConsumerOption func(*consumerOptions)
+
+type consumerOptions struct {
+	selector     string
+	filter       *selector // parsed selector, set by the in-memory client
+	subscription string    // durable or shared subscription name
+	durable      bool
+	shared       bool
+	ackMode      int
+}
+
+// WithSelector sets a JMS message selector, so the consumer only receives
+// messages whose header fields and properties match it. A malformed selector
+// gives an *Error matching ErrInvalidSelector when the consumer is created.
+func WithSelector(selector string) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.selector = selector
+	}
+}
+
+// WithDurable makes a topic consumer a durable subscription with the given
+// name. The subscription, identified by the name and the client ID, keeps
+// messages published while no consumer is open until Unsubscribe is called.
+func WithDurable(name string) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.subscription = name
+		o.durable = true
+		o.shared = false
+	}
+}
+
+// WithShared makes a topic consumer part of a shared subscription with the
+// given name. Each message is delivered to one of the subscription's
+// consumers, and the subscription ends when its last consumer is closed.
+func WithShared(name string) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.subscription = name
+		o.durable = false
+		o.shared = true
+	}
+}
+
+// WithSharedDurable makes a topic consumer part of a shared durable
+// subscription with the given name, which keeps messages while no consumer
+// is open until Unsubscribe is called.
+func WithSharedDurable(name string) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.subscription = name
+		o.durable = true
+		o.shared = true
+	}
+}
+
+// WithAcknowledgeMode sets the acknowledge mode of the consumer's session:
+// TIBEMS_AUTO_ACKNOWLEDGE (the default), TIBEMS_CLIENT_ACKNOWLEDGE,
+// TIBEMS_DUPS_OK_ACKNOWLEDGE, TIBEMS_NO_ACKNOWLEDGE,
+// TIBEMS_EXPLICIT_CLIENT_ACKNOWLEDGE or
+// TIBEMS_EXPLICIT_CLIENT_DUPS_OK_ACKNOWLEDGE. Messages received in the
+// client modes must be acknowledged with Message.Ack.
+func WithAcknowledgeMode(mode int) ConsumerOption {
+	return func(o *consumerOptions) {
+		o.ackMode = mode
+	}
+}
+
+// clientAcknowledge reports whether messages received in the consumer's
+// acknowledge mode must be acknowledged by the application.
+func (o *consumerOptions) clientAcknowledge() bool {
+	switch o.ackMode {
+	case TIBEMS_CLIENT_ACKNOWLEDGE, TIBEMS_EXPLICIT_CLIENT_ACKNOWLEDGE, TIBEMS_EXPLICIT_CLIENT_DUPS_OK_ACKNOWLEDGE:
+		return true
+	}
+	return false
+}
+
+// newConsumerOptions applies opts and validates the result for a consumer of
+// the given destination type.
+func newConsumerOptions(destinationType string, opts []ConsumerOption) (*consumerOptions, error) {
+
+	o := &consumerOptions{ackMode: TIBEMS_AUTO_ACKNOWLEDGE}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	switch o.ackMode {
+	case TIBEMS_AUTO_ACKNOWLEDGE, TIBEMS_CLIENT_ACKNOWLEDGE, TIBEMS_DUPS_OK_ACKNOWLEDGE, TIBEMS_NO_ACKNOWLEDGE,
+		TIBEMS_EXPLICIT_CLIENT_ACKNOWLEDGE, TIBEMS_EXPLICIT_CLIENT_DUPS_OK_ACKNOWLEDGE:
+	default:
+		return nil, fmt.Errorf("invalid acknowledge mode %d", o.ackMode)
+	}
+
+	if (o.durable || o.shared) && o.subscription == "" {
+		return nil, errors.New("subscription name is empty")
+	}
+
+	if o.subscription != "" && !isTopic(destinationType) {
+		return nil, newError(TIBEMS_INVALID_DESTINATION, "durable and shared subscriptions need a topic", "")
+	}
+
+	return o, nil
+}
@@ -0,0 +1,185 @@
+package ems
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestClientOptions_SetServerUrl(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://a:7222, tcp://b:7222")
+
+	urls := ops.GetServerUrls()
+	if len(urls) != 2 {
+		t.Fatalf("bad server url count %d", len(urls))
+	}
+
+	if urls[0].Host != "a:7222" || urls[1].Host != "b:7222" {
+		t.Fatalf("bad server hosts")
+	}
+
+	if ops.GetServerUrl().Host != "a:7222" {
+		t.Fatalf("bad primary server host")
+	}
+
+	if ops.serverURLString() != "tcp://a:7222,tcp://b:7222" {
+		t.Fatalf("bad server url string %q", ops.serverURLString())
+	}
+}
+
+func TestClientOptions_Reconnect(t *testing.T) {
+
+	ops := NewClientOptions().
+		SetReconnectAttemptCount(10).
+		SetReconnectAttemptDelay(500).
+		SetReconnectAttemptTimeout(2000)
+
+	if ops.GetReconnectAttemptCount() != 10 {
+		t.Fatalf("bad reconnect attempt count")
+	}
+
+	if ops.GetReconnectAttemptDelay() != 500 {
+		t.Fatalf("bad reconnect attempt delay")
+	}
+
+	if ops.GetReconnectAttemptTimeout() != 2000 {
+		t.Fatalf("bad reconnect attempt timeout")
+	}
+}
+
+func TestConsumerOptions(t *testing.T) {
+
+	o, err := newConsumerOptions("topic", []ConsumerOption{WithSharedDurable("orders"), WithAcknowledgeMode(TIBEMS_EXPLICIT_CLIENT_ACKNOWLEDGE)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !o.shared || !o.durable || o.subscription != "orders" || !o.clientAcknowledge() {
+		t.Fatalf("bad consumer options %+v", o)
+	}
+
+	if _, err := newConsumerOptions("queue", []ConsumerOption{WithAcknowledgeMode(99)}); err == nil {
+		t.Fatalf("invalid acknowledge mode accepted")
+	}
+
+	if _, err := newConsumerOptions("topic", []ConsumerOption{WithDurable("")}); err == nil {
+		t.Fatalf("empty subscription name accepted")
+	}
+}
+
+func TestClientOptions_SSL(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://a:7222")
+	if ops.usesSSL() {
+		t.Fatalf("tcp options use SSL")
+	}
+
+	if !ops.GetSSLVerifyHost() || !ops.GetSSLVerifyHostName() {
+		t.Fatalf("host verification is off by default")
+	}
+
+	if !NewClientOptions().SetServerUrl("tcp://a:7222,ssl://b:7243").usesSSL() {
+		t.Fatalf("ssl url does not use SSL")
+	}
+
+	ops.SetSSLTrustedCertificates("ca.pem").
+		SetSSLIdentity("client.p12", "").
+		SetSSLVerifyHostName(false).
+		SetSSLExpectedHostName("ems-server")
+
+	if !ops.usesSSL() {
+		t.Fatalf("SSL options not used")
+	}
+
+	if len(ops.GetSSLTrustedCertificates()) != 1 || ops.GetSSLVerifyHostName() || ops.GetSSLExpectedHostName() != "ems-server" {
+		t.Fatalf("bad SSL options")
+	}
+}
+
+func TestClientOptions_Validate(t *testing.T) {
+
+	valid := NewClientOptions().SetServerUrl("tcp://a:7222,tcp://b:7222").SetUsername("admin")
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		ops  *ClientOptions
+		msg  string
+	}{
+		{"no url", NewClientOptions(), "server URL is not set"},
+		{"bad url", NewClientOptions().SetServerUrl("tcp://a:7222,tcp://%zz"), "invalid server URL"},
+		{"bad scheme", NewClientOptions().SetServerUrl("http://a:7222"), "unsupported scheme"},
+		{"no scheme", NewClientOptions().SetServerUrl("a:7222"), "unsupported scheme"},
+		{"no host", NewClientOptions().SetServerUrl("tcp://:7222"), "has no host"},
+		{"mixed schemes", NewClientOptions().SetServerUrl("tcp://a:7222,ssl://b:7243"), "mix"},
+		{"ssl on tcp", NewClientOptions().SetServerUrl("tcp://a:7222").SetSSLTrustedCertificates("ca.pem"), "no server URL uses ssl"},
+		{"password only", NewClientOptions().SetServerUrl("tcp://a:7222").SetPassword("secret"), "without a username"},
+		{"negative", NewClientOptions().SetServerUrl("tcp://a:7222").SetConnectAttemptCount(-1), "negative"},
+		{"reconnect delay", NewClientOptions().SetServerUrl("tcp://a:7222").SetReconnectAttemptDelay(500), "reconnect attempt count is 0"},
+	} {
+		err := tc.ops.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.msg, err)
+		}
+	}
+}
+
+func TestNewClientWithOptions(t *testing.T) {
+
+	var logged []string
+	logger := loggerFunc(func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	})
+
+	o, err := applyClientOptions([]ClientOption{
+		WithServerURL("tcp://options:7222"),
+		WithCredentials("admin", "secret"),
+		WithClientID("billing"),
+		WithReconnect(10, 500, 1000),
+		WithLogger(logger),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if o.GetClientID() != "billing" || o.GetReconnectAttemptCount() != 10 {
+		t.Fatalf("options not applied")
+	}
+
+	c := NewMemoryClient(o)
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	c.Disconnect()
+
+	if len(logged) != 2 {
+		t.Fatalf("expected connect and disconnect to be logged, got %q", logged)
+	}
+
+	if _, err := NewClientWithOptions(WithServerURL("http://options:7222")); err == nil {
+		t.Fatalf("expected invalid options error")
+	}
+}
+
+type loggerFunc func(format string, v ...interface{})
+
+func (f loggerFunc) Printf(format string, v ...interface{}) {
+	f(format, v...)
+}
+
+func TestClientOptions_LookupURL(t *testing.T) {
+
+	ops := NewClientOptions().SetServerUrl("tcp://a:7222,ssl://b:7243").SetConnectionFactoryName("FTConnectionFactory")
+
+	if ops.lookupURLString() != "tibjmsnaming://a:7222,tibjmsnaming://b:7243" {
+		t.Fatalf("bad lookup url %q", ops.lookupURLString())
+	}
+
+	if ops.GetConnectionFactoryName() != "FTConnectionFactory" {
+		t.Fatalf("bad connection factory name")
+	}
+}
@@ -0,0 +1,259 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+*/
+import "C"
+import (
+	"context"
+	"sync"
+	"unsafe"
+)
+
+// replyQueue is the temporary queue a client receives its replies on, shared
+// by all requests and kept until the client disconnects.
+type replyQueue struct {
+	sub    *Subscription
+	router *replyRouter
+}
+
+func (c *Client) Request(destination string, destinationType string, request Message, timeout int, deliveryMode string, expiration int) (Message, bool, error) {
+	return c.RequestContext(context.Background(), destination, destinationType, request, timeout, deliveryMode, expiration)
+}
+
+// RequestContext sends the request with the client's temporary reply queue
+// as its JMSReplyTo and a new JMSCorrelationID, replacing any set on request,
+// then waits up to timeout milliseconds, or indefinitely if timeout is 0, for
+// the reply whose JMSCorrelationID is that ID or the request's JMSMessageID.
+// It reports whether the timeout expired. Concurrent requests share the reply
+// queue.
+func (c *Client) RequestContext(ctx context.Context, destination string, destinationType string, request Message, timeout int, deliveryMode string, expiration int) (Message, bool, error) {
+
+	if err := ctx.Err(); err != nil {
+		return Message{}, false, err
+	}
+
+	rq, err := c.getReplyQueue()
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	id, replies := rq.router.register()
+	defer rq.router.remove(id)
+
+	// the reply queue is set on the message below
+	request.CorrelationID = id
+	request.ReplyTo = ""
+
+	msg, release, err := c.newMsg(request)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer release()
+
+	status := C.tibemsMsg_SetReplyTo(msg, rq.sub.dest)
+	if status != TIBEMS_OK {
+		return Message{}, false, c.newError(status)
+	}
+
+	err = rq.router.send(id, func() (string, error) {
+		if err := c.sendMsg(destination, destinationType, msg, request.Priority, 0, deliveryMode, expiration); err != nil {
+			return "", err
+		}
+
+		var messageID *C.char
+		if C.tibemsMsg_GetMessageID(msg, &messageID) != TIBEMS_OK {
+			return "", nil
+		}
+		return C.GoString(messageID), nil
+	})
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	return rq.router.wait(ctx, replies, timeout)
+}
+
+// getReplyQueue returns the client's reply queue, creating it on first use.
+func (c *Client) getReplyQueue() (*replyQueue, error) {
+
+	c.replyLock.Lock()
+	defer c.replyLock.Unlock()
+
+	if c.replies != nil {
+		return c.replies, nil
+	}
+
+	rq := &replyQueue{router: newReplyRouter(c.options.logf)}
+	s := &Subscription{client: c, handler: rq.router.deliver, temporary: true}
+
+	// create the session
+	status := C.tibemsConnection_CreateSession(c.conn, &s.session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+
+	// create the temporary queue
+	var queue C.tibemsTemporaryQueue
+	status = C.tibemsSession_CreateTemporaryQueue(s.session, &queue)
+	if status != TIBEMS_OK {
+		err := c.newError(status)
+		C.tibemsSession_Close(s.session)
+		return nil, err
+	}
+	s.dest = C.tibemsDestination(queue)
+
+	// create the consumer
+	var err error
+	s.consumer, err = c.createConsumer(s.session, s.dest, &consumerOptions{ackMode: TIBEMS_AUTO_ACKNOWLEDGE})
+	if err != nil {
+		C.tibemsSession_DeleteTemporaryQueue(s.session, queue)
+		C.tibemsSession_Close(s.session)
+		return nil, err
+	}
+
+	if err := s.listen(); err != nil {
+		return nil, err
+	}
+
+	rq.sub = s
+	c.replies = rq
+
+	return rq, nil
+}
+
+// closeReplyQueue fails the requests waiting for a reply, deletes the reply
+// queue and closes the producer sending replies for Serve.
+func (c *Client) closeReplyQueue() error {
+
+	c.replyLock.Lock()
+	rq := c.replies
+	c.replies = nil
+	rp := c.replier
+	c.replier = nil
+	c.replyLock.Unlock()
+
+	var err error
+
+	if rp != nil {
+		err = rp.close()
+	}
+
+	if rq != nil {
+		rq.router.close()
+		if e := rq.sub.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// replyProducer is a producer with no destination on its own session. Serve
+// sends replies through it, so that the temporary reply queue of every
+// requestor does not leave a cached session and producer behind.
+type replyProducer struct {
+	session  C.tibemsSession
+	producer C.tibemsMsgProducer
+	closed   bool
+	sync.Mutex
+}
+
+// sendReply sends a reply to the destination with the unbound reply producer.
+func (c *Client) sendReply(destination string, destinationType string, reply Message) error {
+
+	msg, release, err := c.newMsg(reply)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	rp, err := c.getReplyProducer()
+	if err != nil {
+		return err
+	}
+
+	var dest C.tibemsDestination
+
+	name := C.CString(destination)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsDestination_Create(&dest, toDestinationType(destinationType), name)
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+	defer C.tibemsDestination_Destroy(dest)
+
+	rp.Lock()
+	defer rp.Unlock()
+
+	if rp.closed {
+		return newError(TIBEMS_ILLEGAL_STATE, "reply producer is closed", "")
+	}
+
+	if err := c.setProducerOptions(rp.producer, reply.Priority, 0, "non_persistent", 0); err != nil {
+		return err
+	}
+
+	status = C.tibemsMsgProducer_SendToDestination(rp.producer, dest, msg)
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+// getReplyProducer returns the client's reply producer, creating it on first use.
+func (c *Client) getReplyProducer() (*replyProducer, error) {
+
+	c.replyLock.Lock()
+	defer c.replyLock.Unlock()
+
+	if c.replier != nil {
+		return c.replier, nil
+	}
+
+	rp := &replyProducer{}
+
+	status := C.tibemsConnection_CreateSession(c.conn, &rp.session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+
+	// a nil destination gives a producer that names the destination on each send
+	status = C.tibemsSession_CreateProducer(rp.session, &rp.producer, nil)
+	if status != TIBEMS_OK {
+		err := c.newError(status)
+		C.tibemsSession_Close(rp.session)
+		return nil, err
+	}
+
+	c.replier = rp
+
+	return rp, nil
+}
+
+func (rp *replyProducer) close() error {
+
+	rp.Lock()
+	defer rp.Unlock()
+
+	if rp.closed {
+		return nil
+	}
+	rp.closed = true
+
+	var err error
+
+	if status := C.tibemsMsgProducer_Close(rp.producer); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close producer", "")
+	}
+
+	if status := C.tibemsSession_Close(rp.session); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close session", "")
+	}
+
+	return err
+}
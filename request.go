@@ -0,0 +1,169 @@
+package ems
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sendReceiveTimeout is how long SendReceive waits for a reply, in
+// milliseconds, when its context has no deadline.
+const sendReceiveTimeout = 30000
+
+// errNoReply is returned by SendReceive when no reply arrives in time.
+var errNoReply = newError(TIBEMS_TIMEOUT, "no reply received", "")
+
+// sendReceiveWait returns the request timeout used by SendReceive: none if
+// ctx has a deadline, which then bounds the wait, or sendReceiveTimeout.
+func sendReceiveWait(ctx context.Context) int {
+	if _, ok := ctx.Deadline(); ok {
+		return 0
+	}
+	return sendReceiveTimeout
+}
+
+// replyRouter passes the replies arriving on a client's temporary reply queue
+// to the requests waiting for them. A reply is matched on its
+// JMSCorrelationID, which may be the correlation ID stamped on the request or,
+// following the usual JMS convention, the request's JMSMessageID.
+type replyRouter struct {
+	prefix  string
+	seq     uint64
+	pending map[string]*pendingRequest
+	closed  chan struct{}
+	logf    func(format string, v ...interface{})
+	sync.Mutex
+}
+
+// pendingRequest is a request waiting for its reply, registered under both
+// of its IDs.
+type pendingRequest struct {
+	correlationID string
+	messageID     string
+	replies       chan Message
+}
+
+func newReplyRouter(logf func(format string, v ...interface{})) *replyRouter {
+
+	// a random prefix keeps correlation IDs unique across clients sharing a responder
+	var b [8]byte
+	rand.Read(b[:])
+
+	return &replyRouter{
+		prefix:  "REQ:" + hex.EncodeToString(b[:]) + ":",
+		pending: make(map[string]*pendingRequest),
+		closed:  make(chan struct{}),
+		logf:    logf,
+	}
+}
+
+// register returns a new correlation ID and the channel its reply is passed on.
+// The caller must remove the ID once it stops waiting.
+func (r *replyRouter) register() (string, chan Message) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.seq++
+	p := &pendingRequest{
+		correlationID: r.prefix + strconv.FormatUint(r.seq, 10),
+		replies:       make(chan Message, 1),
+	}
+	r.pending[p.correlationID] = p
+
+	return p.correlationID, p.replies
+}
+
+// send sends the request registered under id and registers the JMSMessageID
+// send returns as well. The router is locked while sending, so a reply
+// arriving before send returns is not dropped.
+func (r *replyRouter) send(id string, send func() (string, error)) error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	messageID, err := send()
+	if err != nil {
+		return err
+	}
+
+	if p, ok := r.pending[id]; ok && messageID != "" {
+		p.messageID = messageID
+		r.pending[messageID] = p
+	}
+
+	return nil
+}
+
+func (r *replyRouter) remove(id string) {
+	r.Lock()
+	if p, ok := r.pending[id]; ok {
+		r.forget(p)
+	}
+	r.Unlock()
+}
+
+// forget removes both IDs of a request. r must be locked.
+func (r *replyRouter) forget(p *pendingRequest) {
+	delete(r.pending, p.correlationID)
+	if p.messageID != "" {
+		delete(r.pending, p.messageID)
+	}
+}
+
+// deliver passes a reply to the request it answers. Replies to unknown
+// requests, or to requests that timed out, are logged and dropped.
+func (r *replyRouter) deliver(reply Message) {
+
+	r.Lock()
+	p, ok := r.pending[reply.CorrelationID]
+	if ok {
+		r.forget(p)
+	}
+	r.Unlock()
+
+	if !ok {
+		r.logf("ems: dropped reply %s with unknown correlation ID %q", reply.MessageID, reply.CorrelationID)
+		return
+	}
+
+	p.replies <- reply
+}
+
+// close fails the requests waiting for a reply.
+func (r *replyRouter) close() {
+	r.Lock()
+	defer r.Unlock()
+
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+}
+
+// wait waits up to timeout milliseconds for a reply, or indefinitely if
+// timeout is 0 or less. It reports whether the timeout expired.
+func (r *replyRouter) wait(ctx context.Context, replies chan Message, timeout int) (Message, bool, error) {
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, false, nil
+	case <-expired:
+		return Message{}, true, nil
+	case <-ctx.Done():
+		return Message{}, false, ctx.Err()
+	case <-r.closed:
+		return Message{}, false, newError(TIBEMS_INVALID_CONNECTION, "connection closed", "")
+	}
+}
@@ -0,0 +1,963 @@
+package ems
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// selector is a parsed JMS message selector, used by the in-memory client to
+// filter messages. The EMS client passes selectors to the server unparsed.
+//
+// The supported syntax is the SQL92 subset defined by JMS: comparisons,
+// arithmetic, AND, OR, NOT, [NOT] BETWEEN, [NOT] IN, [NOT] LIKE with ESCAPE,
+// IS [NOT] NULL, string, numeric and boolean literals, properties and the
+// JMSPriority, JMSMessageID, JMSCorrelationID, JMSType and JMSTimestamp
+// header fields.
+type selector struct {
+	text string
+	expr selectorNode
+}
+
+// parseSelector parses a message selector. An empty selector gives a nil
+// selector, which matches every message.
+func parseSelector(text string) (*selector, error) {
+
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	expr, err := parseSelectorExpr(text)
+	if err != nil {
+		return nil, newError(TIBEMS_INVALID_SELECTOR, fmt.Sprintf("invalid selector %q: %v", text, err), "")
+	}
+
+	return &selector{text: text, expr: expr}, nil
+}
+
+// match reports whether the selector evaluates to true for m.
+// Unknown values, such as missing properties, do not match.
+func (s *selector) match(m *Message) bool {
+
+	if s == nil {
+		return true
+	}
+
+	b, ok := s.expr.eval(m).(bool)
+	return ok && b
+}
+
+func parseSelectorExpr(text string) (selectorNode, error) {
+
+	tokens, err := lexSelector(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &selectorParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", t.text, t.pos)
+	}
+
+	if !isBoolean(expr) {
+		return nil, errors.New("selector is not a boolean expression")
+	}
+
+	return expr, nil
+}
+
+// selector tokens
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokKeyword
+)
+
+type selectorToken struct {
+	kind  int
+	text  string
+	value interface{} // string, int64 or float64 literal value
+	pos   int
+}
+
+var selectorKeywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "BETWEEN": true, "IN": true, "LIKE": true,
+	"ESCAPE": true, "IS": true, "NULL": true, "TRUE": true, "FALSE": true,
+}
+
+func lexSelector(text string) ([]selectorToken, error) {
+
+	var tokens []selectorToken
+
+	for i := 0; i < len(text); {
+		ch := text[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+
+		case ch == '\'':
+			// strings are quoted with ' and a quote is written as ''
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(text) {
+					return nil, fmt.Errorf("unterminated string at %d", i)
+				}
+				if text[j] == '\'' {
+					if j+1 < len(text) && text[j+1] == '\'' {
+						b.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(text[j])
+				j++
+			}
+			tokens = append(tokens, selectorToken{kind: tokString, text: text[i : j+1], value: b.String(), pos: i})
+			i = j + 1
+
+		case ch == '0' && i+2 < len(text) && (text[i+1] == 'x' || text[i+1] == 'X') && isHexDigit(text[i+2]):
+			// Java hexadecimal literals, as EMS accepts them
+			j := i + 2
+			for j < len(text) && isHexDigit(text[j]) {
+				j++
+			}
+			value, err := strconv.ParseInt(text[i+2:j], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %s at %d", text[i:j], i)
+			}
+			if j < len(text) && (text[j] == 'l' || text[j] == 'L') {
+				j++
+			}
+			tokens = append(tokens, selectorToken{kind: tokNumber, text: text[i:j], value: value, pos: i})
+			i = j
+
+		case isDigit(ch) || (ch == '.' && i+1 < len(text) && isDigit(text[i+1])):
+			j := i
+			float := false
+			for j < len(text) && isDigit(text[j]) {
+				j++
+			}
+			if j < len(text) && text[j] == '.' {
+				float = true
+				j++
+				for j < len(text) && isDigit(text[j]) {
+					j++
+				}
+			}
+			if j < len(text) && (text[j] == 'e' || text[j] == 'E') {
+				float = true
+				j++
+				if j < len(text) && (text[j] == '+' || text[j] == '-') {
+					j++
+				}
+				for j < len(text) && isDigit(text[j]) {
+					j++
+				}
+			}
+
+			var value interface{}
+			var err error
+			if float {
+				value, err = strconv.ParseFloat(text[i:j], 64)
+			} else {
+				// a leading 0 makes a Java octal literal
+				value, err = strconv.ParseInt(text[i:j], 0, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("bad number %s at %d", text[i:j], i)
+			}
+
+			// Java type suffixes
+			if j < len(text) {
+				switch text[j] {
+				case 'l', 'L':
+					if !float {
+						j++
+					}
+				case 'f', 'F', 'd', 'D':
+					if f, ok := value.(int64); ok {
+						value = float64(f)
+					}
+					j++
+				}
+			}
+			tokens = append(tokens, selectorToken{kind: tokNumber, text: text[i:j], value: value, pos: i})
+			i = j
+
+		case isIdentStart(ch):
+			j := i + 1
+			for j < len(text) && (isIdentStart(text[j]) || isDigit(text[j])) {
+				j++
+			}
+			word := text[i:j]
+			if upper := strings.ToUpper(word); selectorKeywords[upper] {
+				tokens = append(tokens, selectorToken{kind: tokKeyword, text: upper, pos: i})
+			} else {
+				tokens = append(tokens, selectorToken{kind: tokIdent, text: word, pos: i})
+			}
+			i = j
+
+		default:
+			op := ""
+			if i+1 < len(text) {
+				switch text[i : i+2] {
+				case "<>", "<=", ">=":
+					op = text[i : i+2]
+				}
+			}
+			if op == "" && strings.IndexByte("=<>+-*/(),", ch) >= 0 {
+				op = text[i : i+1]
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", ch, i)
+			}
+			tokens = append(tokens, selectorToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, selectorToken{kind: tokEOF, text: "end of selector", pos: len(text)}), nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
+
+func isIdentStart(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch == '$'
+}
+
+// selector parser
+
+type selectorParser struct {
+	tokens []selectorToken
+	pos    int
+}
+
+func (p *selectorParser) peek() selectorToken {
+	return p.tokens[p.pos]
+}
+
+func (p *selectorParser) next() selectorToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *selectorParser) accept(kind int, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) expect(kind int, text string) error {
+	if !p.accept(kind, text) {
+		t := p.peek()
+		return fmt.Errorf("expected %s but found %s at %d", text, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *selectorParser) parseOr() (selectorNode, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(tokKeyword, "OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if !isBoolean(left) || !isBoolean(right) {
+			return nil, errors.New("OR needs boolean operands")
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (selectorNode, error) {
+
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept(tokKeyword, "AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if !isBoolean(left) || !isBoolean(right) {
+			return nil, errors.New("AND needs boolean operands")
+		}
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *selectorParser) parseNot() (selectorNode, error) {
+
+	if !p.accept(tokKeyword, "NOT") {
+		return p.parseComparison()
+	}
+
+	expr, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if !isBoolean(expr) {
+		return nil, errors.New("NOT needs a boolean operand")
+	}
+
+	return notNode{expr}, nil
+}
+
+func (p *selectorParser) parseComparison() (selectorNode, error) {
+
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+
+	if t.kind == tokOp {
+		switch t.text {
+		case "=", "<>", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return compareNode{t.text, left, right}, nil
+		}
+		return left, nil
+	}
+
+	// NOT is part of the comparison only before BETWEEN, IN and LIKE
+	negate := false
+	if t.kind == tokKeyword && t.text == "NOT" {
+		switch p.tokens[p.pos+1].text {
+		case "BETWEEN", "IN", "LIKE":
+			negate = true
+			p.next()
+			t = p.peek()
+		}
+	}
+
+	if t.kind != tokKeyword {
+		return left, nil
+	}
+
+	var node selectorNode
+
+	switch t.text {
+	case "BETWEEN":
+		p.next()
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokKeyword, "AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		node = betweenNode{left, low, high}
+
+	case "IN":
+		p.next()
+		ident, ok := left.(identNode)
+		if !ok {
+			return nil, errors.New("IN needs an identifier")
+		}
+		if err := p.expect(tokOp, "("); err != nil {
+			return nil, err
+		}
+		values := make(map[string]bool)
+		for {
+			s := p.next()
+			if s.kind != tokString {
+				return nil, fmt.Errorf("expected string but found %s at %d", s.text, s.pos)
+			}
+			values[s.value.(string)] = true
+			if !p.accept(tokOp, ",") {
+				break
+			}
+		}
+		if err := p.expect(tokOp, ")"); err != nil {
+			return nil, err
+		}
+		node = inNode{ident, values}
+
+	case "LIKE":
+		p.next()
+		ident, ok := left.(identNode)
+		if !ok {
+			return nil, errors.New("LIKE needs an identifier")
+		}
+		pattern := p.next()
+		if pattern.kind != tokString {
+			return nil, fmt.Errorf("expected pattern but found %s at %d", pattern.text, pattern.pos)
+		}
+		escape := ""
+		if p.accept(tokKeyword, "ESCAPE") {
+			e := p.next()
+			if e.kind != tokString || len([]rune(e.value.(string))) != 1 {
+				return nil, fmt.Errorf("ESCAPE needs a single character at %d", e.pos)
+			}
+			escape = e.value.(string)
+		}
+		re, err := likePattern(pattern.value.(string), escape)
+		if err != nil {
+			return nil, err
+		}
+		node = likeNode{ident, re}
+
+	case "IS":
+		if negate {
+			return nil, fmt.Errorf("unexpected IS at %d", t.pos)
+		}
+		p.next()
+		ident, ok := left.(identNode)
+		if !ok {
+			return nil, errors.New("IS NULL needs an identifier")
+		}
+		not := p.accept(tokKeyword, "NOT")
+		if err := p.expect(tokKeyword, "NULL"); err != nil {
+			return nil, err
+		}
+		return nullNode{ident, not}, nil
+
+	default:
+		if negate {
+			return nil, fmt.Errorf("unexpected %s at %d", t.text, t.pos)
+		}
+		return left, nil
+	}
+
+	if negate {
+		node = notNode{node}
+	}
+
+	return node, nil
+}
+
+func (p *selectorParser) parseAdditive() (selectorNode, error) {
+
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		if !isNumeric(left) || !isNumeric(right) {
+			return nil, fmt.Errorf("%s needs numeric operands", t.text)
+		}
+		left = arithNode{t.text, left, right}
+	}
+}
+
+func (p *selectorParser) parseMultiplicative() (selectorNode, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if !isNumeric(left) || !isNumeric(right) {
+			return nil, fmt.Errorf("%s needs numeric operands", t.text)
+		}
+		left = arithNode{t.text, left, right}
+	}
+}
+
+func (p *selectorParser) parseUnary() (selectorNode, error) {
+
+	if p.accept(tokOp, "+") || p.accept(tokOp, "-") {
+		op := p.tokens[p.pos-1].text
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if !isNumeric(expr) {
+			return nil, fmt.Errorf("unary %s needs a numeric operand", op)
+		}
+		if op == "+" {
+			return expr, nil
+		}
+		return negNode{expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *selectorParser) parsePrimary() (selectorNode, error) {
+
+	t := p.next()
+
+	switch t.kind {
+	case tokString, tokNumber:
+		return literalNode{t.value}, nil
+	case tokIdent:
+		return identNode{t.text}, nil
+	case tokKeyword:
+		switch t.text {
+		case "TRUE":
+			return literalNode{true}, nil
+		case "FALSE":
+			return literalNode{false}, nil
+		}
+	case tokOp:
+		if t.text == "(" {
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokOp, ")"); err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %s at %d", t.text, t.pos)
+}
+
+// likePattern converts a LIKE pattern, where % matches any sequence and _
+// any single character, to an anchored regular expression.
+func likePattern(pattern string, escape string) (*regexp.Regexp, error) {
+
+	var b strings.Builder
+	b.WriteString("(?s)^")
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != "" && string(r) == escape:
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("LIKE pattern ends with the escape character")
+	}
+
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// isBoolean reports whether a node can be used as a condition.
+func isBoolean(n selectorNode) bool {
+	switch v := n.(type) {
+	case andNode, orNode, notNode, compareNode, betweenNode, inNode, likeNode, nullNode, identNode:
+		return true
+	case literalNode:
+		_, ok := v.value.(bool)
+		return ok
+	}
+	return false
+}
+
+// isNumeric reports whether a node can be used in arithmetic.
+func isNumeric(n selectorNode) bool {
+	switch v := n.(type) {
+	case arithNode, negNode, identNode:
+		return true
+	case literalNode:
+		switch v.value.(type) {
+		case int64, float64:
+			return true
+		}
+	}
+	return false
+}
+
+// selector evaluation
+//
+// Values are bool, int64, float64 or string, with nil for unknown, so
+// comparisons and logic follow the SQL three-valued rules.
+
+type selectorNode interface {
+	eval(m *Message) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+type identNode struct {
+	name string
+}
+
+type andNode struct {
+	left, right selectorNode
+}
+
+type orNode struct {
+	left, right selectorNode
+}
+
+type notNode struct {
+	expr selectorNode
+}
+
+type compareNode struct {
+	op          string
+	left, right selectorNode
+}
+
+type arithNode struct {
+	op          string
+	left, right selectorNode
+}
+
+type negNode struct {
+	expr selectorNode
+}
+
+type betweenNode struct {
+	expr, low, high selectorNode
+}
+
+type inNode struct {
+	ident  identNode
+	values map[string]bool
+}
+
+type likeNode struct {
+	ident   identNode
+	pattern *regexp.Regexp
+}
+
+type nullNode struct {
+	ident identNode
+	not   bool
+}
+
+func (n literalNode) eval(m *Message) interface{} {
+	return n.value
+}
+
+func (n identNode) eval(m *Message) interface{} {
+
+	switch n.name {
+	case "JMSPriority":
+		return int64(m.Priority)
+	case "JMSMessageID":
+		return optionalString(m.MessageID)
+	case "JMSCorrelationID":
+		return optionalString(m.CorrelationID)
+	case "JMSType":
+		return optionalString(m.Type)
+	case "JMSTimestamp":
+		if m.Timestamp.IsZero() {
+			return nil
+		}
+		return m.Timestamp.UnixMilli()
+	}
+
+	switch v := m.Properties[n.name].(type) {
+	case bool, int64, float64, string:
+		return v
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+
+	return nil
+}
+
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (n andNode) eval(m *Message) interface{} {
+
+	l, r := n.left.eval(m), n.right.eval(m)
+	if l == false || r == false {
+		return false
+	}
+	if l == true && r == true {
+		return true
+	}
+
+	return nil
+}
+
+func (n orNode) eval(m *Message) interface{} {
+
+	l, r := n.left.eval(m), n.right.eval(m)
+	if l == true || r == true {
+		return true
+	}
+	if l == false && r == false {
+		return false
+	}
+
+	return nil
+}
+
+func (n notNode) eval(m *Message) interface{} {
+
+	if b, ok := n.expr.eval(m).(bool); ok {
+		return !b
+	}
+
+	return nil
+}
+
+func (n compareNode) eval(m *Message) interface{} {
+
+	l, r := n.left.eval(m), n.right.eval(m)
+
+	// strings and booleans only compare for equality with the same type
+	switch lv := l.(type) {
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil
+		}
+		return equality(n.op, lv == rv)
+	case bool:
+		rv, ok := r.(bool)
+		if !ok {
+			return nil
+		}
+		return equality(n.op, lv == rv)
+	}
+
+	c, ok := compareNumbers(l, r)
+	if !ok {
+		return nil
+	}
+
+	switch n.op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func equality(op string, equal bool) interface{} {
+	switch op {
+	case "=":
+		return equal
+	case "<>":
+		return !equal
+	}
+	return nil
+}
+
+func (n arithNode) eval(m *Message) interface{} {
+
+	l, r := n.left.eval(m), n.right.eval(m)
+
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt {
+		switch n.op {
+		case "+":
+			return li + ri
+		case "-":
+			return li - ri
+		case "*":
+			return li * ri
+		default:
+			if ri == 0 {
+				return nil
+			}
+			return li / ri
+		}
+	}
+
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if !lok || !rok {
+		return nil
+	}
+
+	switch n.op {
+	case "+":
+		return lf + rf
+	case "-":
+		return lf - rf
+	case "*":
+		return lf * rf
+	default:
+		return lf / rf
+	}
+}
+
+func (n negNode) eval(m *Message) interface{} {
+
+	switch v := n.expr.eval(m).(type) {
+	case int64:
+		return -v
+	case float64:
+		return -v
+	}
+
+	return nil
+}
+
+func (n betweenNode) eval(m *Message) interface{} {
+
+	v := n.expr.eval(m)
+
+	low, ok := compareNumbers(v, n.low.eval(m))
+	if !ok {
+		return nil
+	}
+
+	high, ok := compareNumbers(v, n.high.eval(m))
+	if !ok {
+		return nil
+	}
+
+	return low >= 0 && high <= 0
+}
+
+func (n inNode) eval(m *Message) interface{} {
+
+	if s, ok := n.ident.eval(m).(string); ok {
+		return n.values[s]
+	}
+
+	return nil
+}
+
+func (n likeNode) eval(m *Message) interface{} {
+
+	if s, ok := n.ident.eval(m).(string); ok {
+		return n.pattern.MatchString(s)
+	}
+
+	return nil
+}
+
+func (n nullNode) eval(m *Message) interface{} {
+	return (n.ident.eval(m) == nil) != n.not
+}
+
+// compareNumbers compares two numeric values, returning false if either is
+// not a number.
+func compareNumbers(l, r interface{}) (int, bool) {
+
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt {
+		switch {
+		case li < ri:
+			return -1, true
+		case li > ri:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if !lok || !rok {
+		return 0, false
+	}
+
+	switch {
+	case lf < rf:
+		return -1, true
+	case lf > rf:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,145 @@
+package ems
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseSelector_Invalid(t *testing.T) {
+
+	for _, text := range []string{
+		"color =",
+		"color = 'red",
+		"3 + 4",
+		"'a' + 1 > 2",
+		"color IN (1, 2)",
+		"color LIKE 'a' ESCAPE 'xy'",
+		"(a = 1",
+		"a = 1 b",
+		"a # 1",
+	} {
+		if _, err := parseSelector(text); !errors.Is(err, ErrInvalidSelector) {
+			t.Errorf("%q: expected ErrInvalidSelector, got %v", text, err)
+		}
+	}
+}
+
+func TestParseSelector_Valid(t *testing.T) {
+
+	// selectors EMS accepts must parse
+	for _, text := range []string{
+		"a = 0x1F",
+		"a = 0X1fL",
+		"a = 017",
+		"a = 10L",
+		"a = 1.5f OR a = 2D",
+		"a = .5 OR a = 5. OR a = 1E-3",
+		"name = 'O''Brien'",
+		"name = ''",
+		"a = TRUE AND b = false",
+		"NOT a = 1 AND b <> 2 OR c >= 3",
+		"((a = 1))",
+		"a + b * c - d / e = -f",
+		"a IN ('x')",
+		"a NOT LIKE '%x%'",
+		"a IS NULL AND b IS NOT NULL",
+		"a BETWEEN -1 AND +1",
+		"JMSDeliveryMode = 'PERSISTENT'",
+		"JMSPriority > 4 AND JMSTimestamp > 0 AND JMSMessageID <> 'x' AND JMSCorrelationID = 'y'",
+		"$a = 1 AND _b = 2 AND c1 = 3",
+		"a = 1 and b = 2 or not c",
+	} {
+		if _, err := parseSelector(text); err != nil {
+			t.Errorf("%q: %v", text, err)
+		}
+	}
+}
+
+func TestParseSelector_Match(t *testing.T) {
+
+	m := NewMessage("body")
+	m.Priority = 7
+	m.Type = "order"
+	m.SetProperty("color", "red")
+	m.SetProperty("size", int32(10))
+	m.SetProperty("weight", 2.5)
+	m.SetProperty("urgent", true)
+	m.SetProperty("code", "A_1%")
+	m.SetProperty("name", "O'Brien")
+	m.MessageID = "ID:1"
+	m.CorrelationID = "c-1"
+	m.Timestamp = time.Now()
+
+	tests := []struct {
+		selector string
+		match    bool
+	}{
+		{"", true},
+		{"color = 'red'", true},
+		{"color <> 'red'", false},
+		{"size > 5 AND weight < 3", true},
+		{"size * 2 = 20", true},
+		{"size / 4 = 2", true},
+		{"-size < 0", true},
+		{"size BETWEEN 10 AND 12", true},
+		{"size NOT BETWEEN 10 AND 12", false},
+		{"color IN ('green', 'red')", true},
+		{"color NOT IN ('green', 'red')", false},
+		{"color LIKE 'r_%'", true},
+		{"code LIKE 'A!_1!%' ESCAPE '!'", true},
+		{"code LIKE 'A!_2%' ESCAPE '!'", false},
+		{"urgent", true},
+		{"NOT urgent OR JMSPriority = 7", true},
+		{"JMSType = 'order'", true},
+		{"missing IS NULL", true},
+		{"missing IS NOT NULL", false},
+		{"missing = 1", false},
+		{"NOT (missing = 1)", false},
+		{"missing = 1 OR size = 10", true},
+		{"color = 10", false},
+		{"weight = 2.5e0", true},
+
+		// precedence: NOT over AND over OR, * over +
+		{"color = 'blue' AND size = 10 OR urgent", true},
+		{"urgent OR color = 'blue' AND size = 99", true},
+		{"(urgent OR color = 'blue') AND size = 99", false},
+		{"NOT color = 'blue' AND size = 10", true},
+		{"size + 2 * 5 = 20", true},
+		{"(size + 2) * 5 = 60", true},
+		{"size - 4 - 3 = 3", true},
+
+		// string literals with '' escapes
+		{"name = 'O''Brien'", true},
+		{"name LIKE '%''%'", true},
+		{"name = 'OBrien'", false},
+
+		// int and float compare by value
+		{"size = 10.0", true},
+		{"size < 10.5", true},
+		{"weight > 2", true},
+		{"weight BETWEEN 2 AND 3", true},
+		{"size / 4.0 = 2.5", true},
+		{"size = 0xA", true},
+		{"size = 012", true},
+		{"size = 10L", true},
+
+		// header fields
+		{"JMSMessageID = 'ID:1'", true},
+		{"JMSCorrelationID = 'c-1'", true},
+		{"JMSCorrelationID IS NULL", false},
+		{"JMSTimestamp > 0", true},
+		{"JMSPriority BETWEEN 5 AND 9", true},
+	}
+
+	for _, test := range tests {
+		s, err := parseSelector(test.selector)
+		if err != nil {
+			t.Errorf("%q: %v", test.selector, err)
+			continue
+		}
+		if s.match(&m) != test.match {
+			t.Errorf("%q: expected match %v", test.selector, test.match)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ems
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RequestHandler handles a request delivered by Serve and returns its reply.
+type RequestHandler func(req Message) (Message, error)
+
+// Properties set on the replies sent by Serve.
+const (
+	ReplyStatusProperty = "EMS_REPLY_STATUS" // ReplyStatusOK or ReplyStatusError
+	ReplyErrorProperty  = "EMS_REPLY_ERROR"  // the handler's error text
+)
+
+const (
+	ReplyStatusOK    = "OK"
+	ReplyStatusError = "ERROR"
+)
+
+// replier is implemented by clients that send replies without caching a
+// producer for each reply destination, as SendMessage does.
+type replier interface {
+	sendReply(destination string, destinationType string, reply Message) error
+}
+
+// server is the subscription returned by Serve.
+type server struct {
+	client  IClient
+	handler RequestHandler
+	sub     ISubscription
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+// serve subscribes to the queue and runs handler for each request on up to
+// concurrency goroutines, sending its reply to the request's ReplyTo.
+func serve(c IClient, destination string, handler RequestHandler, concurrency int, opts []ConsumerOption) (ISubscription, error) {
+
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	s := &server{client: c, handler: handler, slots: make(chan struct{}, concurrency)}
+
+	sub, err := c.Subscribe(destination, "queue", s.dispatch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.sub = sub
+
+	return s, nil
+}
+
+// dispatch waits for a free slot, so no more requests are taken from the
+// queue than there are handlers to run them.
+func (s *server) dispatch(req Message) {
+
+	s.slots <- struct{}{}
+	s.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-s.slots
+			s.wg.Done()
+		}()
+		s.reply(req, s.handle(req))
+	}()
+}
+
+// handle runs the handler, turning an error or panic into an error reply.
+func (s *server) handle(req Message) (reply Message) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			reply = errorReply(fmt.Errorf("handler panic: %v", r))
+		}
+	}()
+
+	reply, err := s.handler(req)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	reply.SetProperty(ReplyStatusProperty, ReplyStatusOK)
+	return reply
+}
+
+// reply sends the reply to the request's reply-to destination. Requests
+// without one are one-way and get no reply.
+func (s *server) reply(req Message, reply Message) {
+
+	if req.ReplyTo == "" {
+		return
+	}
+
+	// requestors match replies on the request's correlation ID, or its message ID
+	reply.CorrelationID = req.CorrelationID
+	if reply.CorrelationID == "" {
+		reply.CorrelationID = req.MessageID
+	}
+
+	replyToType := req.ReplyToType
+	if replyToType == "" {
+		replyToType = "queue"
+	}
+
+	// a reply that cannot be sent is dropped and the requestor times out
+	if r, ok := s.client.(replier); ok {
+		r.sendReply(req.ReplyTo, replyToType, reply)
+		return
+	}
+	s.client.SendMessage(req.ReplyTo, replyToType, reply, 0, "non_persistent", 0)
+}
+
+func errorReply(err error) Message {
+	reply := NewMessage(err.Error())
+	reply.SetProperty(ReplyStatusProperty, ReplyStatusError)
+	reply.SetProperty(ReplyErrorProperty, err.Error())
+	return reply
+}
+
+// Close stops taking requests and waits for the handlers in progress to reply.
+func (s *server) Close() error {
+	err := s.sub.Close()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,110 @@
+package ems
+
+import "fmt"
+
+// StreamWriter appends typed values to the body of a stream message.
+type StreamWriter struct {
+	m *Message
+}
+
+// NewStreamWriter returns a writer appending to the body of m, which becomes
+// a stream message.
+func NewStreamWriter(m *Message) *StreamWriter {
+	m.BodyType = BodyTypeStream
+	return &StreamWriter{m: m}
+}
+
+func (w *StreamWriter) WriteBool(v bool)       { w.append(v) }
+func (w *StreamWriter) WriteInt8(v int8)       { w.append(v) }
+func (w *StreamWriter) WriteInt16(v int16)     { w.append(v) }
+func (w *StreamWriter) WriteInt32(v int32)     { w.append(v) }
+func (w *StreamWriter) WriteInt64(v int64)     { w.append(v) }
+func (w *StreamWriter) WriteFloat32(v float32) { w.append(v) }
+func (w *StreamWriter) WriteFloat64(v float64) { w.append(v) }
+func (w *StreamWriter) WriteString(v string)   { w.append(v) }
+func (w *StreamWriter) WriteBytes(v []byte)    { w.append(v) }
+
+// WriteMap appends a nested map message.
+func (w *StreamWriter) WriteMap(v map[string]interface{}) { w.append(v) }
+
+// WriteStream appends a nested stream message.
+func (w *StreamWriter) WriteStream(v []interface{}) { w.append(v) }
+
+// Write appends a value of any type supported in a stream message.
+func (w *StreamWriter) Write(v interface{}) error {
+	if _, err := copyField(v); err != nil {
+		return err
+	}
+	w.append(v)
+	return nil
+}
+
+func (w *StreamWriter) append(v interface{}) {
+	w.m.Stream = append(w.m.Stream, v)
+}
+
+// StreamReader reads the values of a stream message in order.
+// Reads past the last value return ErrMsgEOF.
+type StreamReader struct {
+	values []interface{}
+	pos    int
+}
+
+// NewStreamReader returns a reader positioned at the first value of m.
+func NewStreamReader(m Message) *StreamReader {
+	return &StreamReader{values: m.Stream}
+}
+
+// Reset moves the reader back to the first value.
+func (r *StreamReader) Reset() {
+	r.pos = 0
+}
+
+// Read returns the next value whatever its type.
+func (r *StreamReader) Read() (interface{}, error) {
+	if r.pos >= len(r.values) {
+		return nil, ErrMsgEOF
+	}
+	v := r.values[r.pos]
+	r.pos++
+	return v, nil
+}
+
+func (r *StreamReader) ReadBool() (bool, error)       { return readStream[bool](r) }
+func (r *StreamReader) ReadInt8() (int8, error)       { return readStream[int8](r) }
+func (r *StreamReader) ReadInt16() (int16, error)     { return readStream[int16](r) }
+func (r *StreamReader) ReadInt32() (int32, error)     { return readStream[int32](r) }
+func (r *StreamReader) ReadInt64() (int64, error)     { return readStream[int64](r) }
+func (r *StreamReader) ReadFloat32() (float32, error) { return readStream[float32](r) }
+func (r *StreamReader) ReadFloat64() (float64, error) { return readStream[float64](r) }
+func (r *StreamReader) ReadString() (string, error)   { return readStream[string](r) }
+func (r *StreamReader) ReadBytes() ([]byte, error)    { return readStream[[]byte](r) }
+
+// ReadMap reads a nested map message.
+func (r *StreamReader) ReadMap() (map[string]interface{}, error) {
+	return readStream[map[string]interface{}](r)
+}
+
+// ReadStream reads a nested stream message.
+func (r *StreamReader) ReadStream() ([]interface{}, error) {
+	return readStream[[]interface{}](r)
+}
+
+// readStream reads the next value as a T. A value of another type is not
+// consumed, so it can be read again with the matching function.
+func readStream[T any](r *StreamReader) (T, error) {
+
+	var zero T
+
+	if r.pos >= len(r.values) {
+		return zero, ErrMsgEOF
+	}
+
+	v, ok := r.values[r.pos].(T)
+	if !ok {
+		return zero, fmt.Errorf("stream field %d is %T, not %T", r.pos, r.values[r.pos], zero)
+	}
+	r.pos++
+
+	return v, nil
+}
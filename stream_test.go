@@ -0,0 +1,55 @@
+package ems
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStreamWriterReader(t *testing.T) {
+
+	var m Message
+
+	w := NewStreamWriter(&m)
+	w.WriteInt32(7)
+	w.WriteString("seven")
+	w.WriteMap(map[string]interface{}{"n": int64(7)})
+
+	if err := w.Write(struct{}{}); err == nil {
+		t.Fatalf("unsupported value written")
+	}
+
+	if m.BodyType != BodyTypeStream || len(m.Stream) != 3 {
+		t.Fatalf("bad stream message %+v", m)
+	}
+
+	r := NewStreamReader(m)
+
+	if _, err := r.ReadString(); err == nil {
+		t.Fatalf("read int32 field as string")
+	}
+
+	n, err := r.ReadInt32()
+	if err != nil || n != 7 {
+		t.Fatalf("bad int32 %v %v", n, err)
+	}
+
+	s, err := r.ReadString()
+	if err != nil || s != "seven" {
+		t.Fatalf("bad string %q %v", s, err)
+	}
+
+	nested, err := r.ReadMap()
+	if err != nil || nested["n"] != int64(7) {
+		t.Fatalf("bad map %v %v", nested, err)
+	}
+
+	if _, err := r.Read(); !errors.Is(err, ErrMsgEOF) {
+		t.Fatalf("expected ErrMsgEOF, got %v", err)
+	}
+
+	r.Reset()
+
+	if v, _ := r.Read(); v != int32(7) {
+		t.Fatalf("reset did not rewind, got %v", v)
+	}
+}
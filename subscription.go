@@ -0,0 +1,246 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+
+extern void emsMessageCallback(tibemsMsgConsumer consumer, tibemsMsg msg, void* closure);
+*/
+import "C"
+import (
+	"errors"
+	"sync"
+	"unsafe"
+)
+
+// Subscription is a message consumer with a listener registered, delivering
+// messages to its handler until it is closed. Each subscription has its own
+// session so handlers for different subscriptions may run concurrently.
+type Subscription struct {
+	client    *Client
+	dest      C.tibemsDestination
+	session   C.tibemsSession
+	consumer  C.tibemsMsgConsumer
+	handler   MessageHandler
+	clientAck bool
+	temporary bool // dest is a temporary queue, deleted when the subscription closes
+	closed    bool
+	sync.Mutex
+}
+
+// listeners maps consumers to their subscriptions for the EMS callback.
+var listeners = struct {
+	sync.RWMutex
+	subs map[C.tibemsMsgConsumer]*Subscription
+}{subs: make(map[C.tibemsMsgConsumer]*Subscription)}
+
+//export emsMessageCallback
+func emsMessageCallback(consumer C.tibemsMsgConsumer, msg C.tibemsMsg, closure unsafe.Pointer) {
+
+	listeners.RLock()
+	s := listeners.subs[consumer]
+	listeners.RUnlock()
+
+	if s == nil {
+		C.tibemsMsg_Destroy(msg)
+		return
+	}
+
+	// messages that cannot be converted are skipped
+	m, err := s.client.readMsg(msg)
+	if err != nil {
+		C.tibemsMsg_Destroy(msg)
+		return
+	}
+
+	// the listener owns the message, unless it waits for an acknowledgement
+	if s.clientAck {
+		m.acker = s.client.newAck(msg, s.session, false)
+	} else {
+		defer C.tibemsMsg_Destroy(msg)
+	}
+
+	s.handler(m)
+}
+
+// Subscribe creates a consumer on the destination and calls handler for every
+// message it receives until the returned subscription is closed.
+// Handler calls for one subscription are serialised.
+func (c *Client) Subscribe(destination string, destinationType string, handler MessageHandler, opts ...ConsumerOption) (ISubscription, error) {
+
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+
+	o, err := newConsumerOptions(destinationType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{client: c, handler: handler, clientAck: o.clientAcknowledge()}
+
+	// create the destination
+	name := C.CString(destination)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsDestination_Create(&s.dest, toDestinationType(destinationType), name)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+
+	// create the session
+	status = C.tibemsConnection_CreateSession(c.conn, &s.session, TIBEMS_FALSE, C.tibemsAcknowledgeMode(o.ackMode))
+	if status != TIBEMS_OK {
+		err := c.newError(status)
+		C.tibemsDestination_Destroy(s.dest)
+		return nil, err
+	}
+
+	// create the consumer
+	s.consumer, err = c.createConsumer(s.session, s.dest, o)
+	if err != nil {
+		C.tibemsSession_Close(s.session)
+		C.tibemsDestination_Destroy(s.dest)
+		return nil, err
+	}
+
+	if err := s.listen(); err != nil {
+		return nil, err
+	}
+
+	c.subsLock.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[*Subscription]struct{})
+	}
+	c.subscriptions[s] = struct{}{}
+	c.subsLock.Unlock()
+
+	return s, nil
+}
+
+// listen sets the listener delivering the consumer's messages to the handler.
+// The subscription is closed if it fails.
+func (s *Subscription) listen() error {
+
+	// register before the listener is set so the first message finds its subscription
+	listeners.Lock()
+	listeners.subs[s.consumer] = s
+	listeners.Unlock()
+
+	status := C.tibemsMsgConsumer_SetMsgListener(s.consumer, (C.tibemsMsgCallback)(unsafe.Pointer(C.emsMessageCallback)), nil)
+	if status != TIBEMS_OK {
+		err := s.client.newError(status)
+		s.close()
+		return err
+	}
+
+	return nil
+}
+
+// Serve answers the requests sent to a queue, running handler on up to
+// concurrency goroutines and sending each reply to the request's ReplyTo
+// with the request's CorrelationID. A handler error is sent back as a text
+// reply whose ReplyStatusProperty is ReplyStatusError. Closing the returned
+// subscription waits for the handlers in progress.
+func (c *Client) Serve(destination string, handler RequestHandler, concurrency int, opts ...ConsumerOption) (ISubscription, error) {
+	return serve(c, destination, handler, concurrency, opts)
+}
+
+// Unsubscribe deletes the durable or shared durable subscription with the
+// given name. It fails while the subscription has an open consumer.
+func (c *Client) Unsubscribe(name string) error {
+
+	var session C.tibemsSession
+
+	status := C.tibemsConnection_CreateSession(c.conn, &session, TIBEMS_FALSE, TIBEMS_AUTO_ACKNOWLEDGE)
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+	defer C.tibemsSession_Close(session)
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	status = C.tibemsSession_Unsubscribe(session, cname)
+	if status != TIBEMS_OK {
+		return c.newError(status)
+	}
+
+	return nil
+}
+
+// Close stops delivery and releases the consumer and its session.
+// It waits for a handler call in progress, so it must not be called from the handler.
+func (s *Subscription) Close() error {
+
+	s.client.subsLock.Lock()
+	delete(s.client.subscriptions, s)
+	s.client.subsLock.Unlock()
+
+	return s.close()
+}
+
+func (s *Subscription) close() error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var err error
+
+	// closing the consumer waits for the listener to return
+	if status := C.tibemsMsgConsumer_Close(s.consumer); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close consumer", "")
+	}
+
+	listeners.Lock()
+	delete(listeners.subs, s.consumer)
+	listeners.Unlock()
+
+	// delete a temporary queue while its session is open
+	if s.temporary {
+		if status := C.tibemsSession_DeleteTemporaryQueue(s.session, C.tibemsTemporaryQueue(s.dest)); status != TIBEMS_OK && err == nil {
+			err = newError(int(status), "failed to delete temporary queue", "")
+		}
+	}
+
+	// messages not yet acknowledged are redelivered once the session closes
+	s.client.releaseAcks(s.session)
+
+	// destroy the session
+	if status := C.tibemsSession_Close(s.session); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close session", "")
+	}
+
+	// destroy the destination
+	if !s.temporary {
+		if status := C.tibemsDestination_Destroy(s.dest); status != TIBEMS_OK && err == nil {
+			err = newError(int(status), "failed to destroy destination", "")
+		}
+	}
+
+	return err
+}
+
+// closeSubscriptions closes every open subscription.
+func (c *Client) closeSubscriptions() error {
+
+	c.subsLock.Lock()
+	subs := c.subscriptions
+	c.subscriptions = nil
+	c.subsLock.Unlock()
+
+	var err error
+	for s := range subs {
+		if e := s.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,281 @@
+//go:build tibems
+
+package ems
+
+/*
+#include <tibems.h>
+*/
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// Tx is a transacted session. Calls on a Tx are serialised, since an EMS
+// session is single threaded.
+type Tx struct {
+	client    *Client
+	session   C.tibemsSession
+	producers map[string]*txProducer
+	closed    bool
+	sync.Mutex
+}
+
+// txProducer is a producer kept open on the transacted session for one destination.
+type txProducer struct {
+	dest     C.tibemsDestination
+	producer C.tibemsMsgProducer
+}
+
+// Begin opens a transacted session. Commit and Rollback return an *Error
+// matching ErrTransactionFailed or ErrTransactionRollback when the server
+// could not commit the transaction.
+func (c *Client) Begin() (ITx, error) {
+
+	t := &Tx{client: c}
+
+	status := C.tibemsConnection_CreateSession(c.conn, &t.session, TIBEMS_TRUE, TIBEMS_SESSION_TRANSACTED)
+	if status != TIBEMS_OK {
+		return nil, c.newError(status)
+	}
+
+	c.txLock.Lock()
+	if c.transactions == nil {
+		c.transactions = make(map[*Tx]struct{})
+	}
+	c.transactions[t] = struct{}{}
+	c.txLock.Unlock()
+
+	return t, nil
+}
+
+func (t *Tx) Send(destination string, destinationType string, message string, deliveryDelay int, deliveryMode string, expiration int) error {
+	return t.SendMessage(destination, destinationType, NewMessage(message), deliveryDelay, deliveryMode, expiration)
+}
+
+// SendMessage sends a message that is delivered when the transaction commits.
+func (t *Tx) SendMessage(destination string, destinationType string, message Message, deliveryDelay int, deliveryMode string, expiration int) error {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return errTxClosed
+	}
+
+	msg, release, err := t.client.newMsg(message)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	p, err := t.getProducer(destination, destinationType)
+	if err != nil {
+		return err
+	}
+
+	return t.client.send(p.producer, msg, message.Priority, deliveryDelay, deliveryMode, expiration)
+}
+
+func (t *Tx) Receive(destination string, destinationType string, timeout int, opts ...ConsumerOption) (string, bool, error) {
+
+	msg, timedOut, err := t.ReceiveMessage(destination, destinationType, timeout, opts...)
+	if err != nil || timedOut {
+		return "", timedOut, err
+	}
+
+	body, err := textBody(msg)
+	return body, false, err
+}
+
+// ReceiveMessage waits up to timeout milliseconds for a message, which is
+// consumed when the transaction commits. The acknowledge mode option is
+// ignored in a transaction.
+func (t *Tx) ReceiveMessage(destination string, destinationType string, timeout int, opts ...ConsumerOption) (Message, bool, error) {
+
+	if timeout <= 0 {
+		return Message{}, false, errTxTimeout
+	}
+
+	o, err := newConsumerOptions(destinationType, opts)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return Message{}, false, errTxClosed
+	}
+
+	var dest C.tibemsDestination
+	var msg C.tibemsMsg
+
+	// create the destination
+	name := C.CString(destination)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsDestination_Create(&dest, toDestinationType(destinationType), name)
+	if status != TIBEMS_OK {
+		return Message{}, false, t.client.newError(status)
+	}
+	defer C.tibemsDestination_Destroy(dest)
+
+	// create the consumer; messages received before it closes stay in the transaction
+	consumer, err := t.client.createConsumer(t.session, dest, o)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer C.tibemsMsgConsumer_Close(consumer)
+
+	status = C.tibemsMsgConsumer_ReceiveTimeout(consumer, &msg, C.tibems_long(timeout))
+	if status == TIBEMS_TIMEOUT {
+		return Message{}, true, nil
+	}
+	if status != TIBEMS_OK {
+		return Message{}, false, t.client.newError(status)
+	}
+	defer C.tibemsMsg_Destroy(msg)
+
+	m, err := t.client.readMsg(msg)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	return m, false, nil
+}
+
+// Commit delivers the messages sent and consumes the messages received since
+// the transaction started.
+func (t *Tx) Commit() error {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return errTxClosed
+	}
+
+	status := C.tibemsSession_Commit(t.session)
+	if status != TIBEMS_OK {
+		return t.client.newError(status)
+	}
+
+	return nil
+}
+
+// Rollback discards the messages sent and redelivers the messages received
+// since the transaction started.
+func (t *Tx) Rollback() error {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return errTxClosed
+	}
+
+	status := C.tibemsSession_Rollback(t.session)
+	if status != TIBEMS_OK {
+		return t.client.newError(status)
+	}
+
+	return nil
+}
+
+// Close rolls back any uncommitted work and closes the session.
+func (t *Tx) Close() error {
+
+	t.client.txLock.Lock()
+	delete(t.client.transactions, t)
+	t.client.txLock.Unlock()
+
+	return t.close()
+}
+
+func (t *Tx) close() error {
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	var err error
+
+	for _, p := range t.producers {
+		if status := C.tibemsMsgProducer_Close(p.producer); status != TIBEMS_OK && err == nil {
+			err = newError(int(status), "failed to close producer", "")
+		}
+		if status := C.tibemsDestination_Destroy(p.dest); status != TIBEMS_OK && err == nil {
+			err = newError(int(status), "failed to destroy destination", "")
+		}
+	}
+	t.producers = nil
+
+	// closing a transacted session rolls back the open transaction
+	if status := C.tibemsSession_Close(t.session); status != TIBEMS_OK && err == nil {
+		err = newError(int(status), "failed to close session", "")
+	}
+
+	return err
+}
+
+// getProducer returns the producer for the destination, creating it on
+// first use. t must be locked.
+func (t *Tx) getProducer(destination string, destinationType string) (*txProducer, error) {
+
+	destType := toDestinationType(destinationType)
+	key := producerKey(destination, destType)
+
+	if p, ok := t.producers[key]; ok {
+		return p, nil
+	}
+
+	p := &txProducer{}
+
+	// create the destination
+	name := C.CString(destination)
+	defer C.free(unsafe.Pointer(name))
+
+	status := C.tibemsDestination_Create(&p.dest, destType, name)
+	if status != TIBEMS_OK {
+		return nil, t.client.newError(status)
+	}
+
+	// create the producer
+	status = C.tibemsSession_CreateProducer(t.session, &p.producer, p.dest)
+	if status != TIBEMS_OK {
+		err := t.client.newError(status)
+		C.tibemsDestination_Destroy(p.dest)
+		return nil, err
+	}
+
+	if t.producers == nil {
+		t.producers = make(map[string]*txProducer)
+	}
+	t.producers[key] = p
+
+	return p, nil
+}
+
+// closeTransactions closes every open transaction, rolling back uncommitted work.
+func (c *Client) closeTransactions() error {
+
+	c.txLock.Lock()
+	txs := c.transactions
+	c.transactions = nil
+	c.txLock.Unlock()
+
+	var err error
+	for t := range txs {
+		if e := t.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}